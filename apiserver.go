@@ -0,0 +1,388 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long each watch request lasts before the API server ends it and
+// we start another, and how long we wait after a failed request
+const (
+	watchTimeout    = 5 * time.Minute
+	watchRetryDelay = time.Second
+)
+
+// The API server's URL inside a pod, from the environment variables
+// that kubelet sets in every container, or "" outside pods
+func inClusterApiServerUrl() string {
+	host := os.Getenv("KUBERNETES_SERVICE_HOST")
+	port := os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return ""
+	}
+	return "https://" + net.JoinHostPort(host, port)
+}
+
+// What a remote IP address belongs to in the cluster: a pod, or a
+// Service's ClusterIP. All fields are "" for IPs outside the cluster.
+type RemoteEndpoint struct {
+	namespace string
+	pod       string
+	service   string
+}
+
+// The fields we use of pods and Services from the API server
+type kubeObject struct {
+	Metadata struct {
+		Name            string
+		Namespace       string
+		ResourceVersion string
+	}
+	Spec struct {
+		HostNetwork bool     // Pods
+		ClusterIP   string   // Services
+		ClusterIPs  []string // Services with more than one IP family
+	}
+	Status struct {
+		Phase  string // Pods
+		PodIP  string
+		PodIPs []struct {
+			IP string
+		}
+	}
+}
+
+// A list of pods or Services
+type kubeObjectList struct {
+	Metadata struct {
+		ResourceVersion string
+	}
+	Items []kubeObject
+}
+
+// One event from a watch. Type is ADDED, MODIFIED, DELETED, BOOKMARK
+// or ERROR.
+type kubeWatchEvent struct {
+	Type   string
+	Object json.RawMessage
+}
+
+// Canonicalize an IP address, so that "::ffff:10.2.9.76" and
+// "10.2.9.76" match. Returns "" if ip isn't an IP address.
+func canonicalIP(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+	return parsed.String()
+}
+
+// The IPs of a pod. Pods on the host network have their node's IP, so
+// they don't get one, and neither do pods that have finished, since
+// new pods can reuse their IPs.
+func podIPs(pod *kubeObject) []string {
+	if pod.Spec.HostNetwork || pod.Status.Phase == "Succeeded" || pod.Status.Phase == "Failed" {
+		return nil
+	}
+
+	ips := []string{pod.Status.PodIP}
+	for _, podIP := range pod.Status.PodIPs {
+		ips = append(ips, podIP.IP)
+	}
+	return ips
+}
+
+// The ClusterIPs of a Service. Headless Services have the ClusterIP
+// "None".
+func serviceIPs(service *kubeObject) []string {
+	return append([]string{service.Spec.ClusterIP}, service.Spec.ClusterIPs...)
+}
+
+type objectKey struct {
+	namespace string
+	name      string
+}
+
+// An ipIndex maps IPs to the pods or Services that have them, and
+// remembers each object's IPs so that it can forget them again
+type ipIndex struct {
+	objects map[objectKey][]string
+	ips     map[string]objectKey
+}
+
+func newIpIndex() ipIndex {
+	return ipIndex{
+		objects: make(map[objectKey][]string),
+		ips:     make(map[string]objectKey),
+	}
+}
+
+func (index *ipIndex) delete(key objectKey) {
+	for _, ip := range index.objects[key] {
+		if index.ips[ip] == key {
+			delete(index.ips, ip)
+		}
+	}
+	delete(index.objects, key)
+}
+
+func (index *ipIndex) set(key objectKey, ips []string) {
+	index.delete(key)
+
+	var canonical []string
+	for _, ip := range ips {
+		ip = canonicalIP(ip)
+		if ip == "" {
+			continue
+		}
+		canonical = append(canonical, ip)
+		index.ips[ip] = key
+	}
+	if len(canonical) > 0 {
+		index.objects[key] = canonical
+	}
+}
+
+// A resource we watch, and how to get IPs from its objects
+type watchedResource struct {
+	path  string
+	ips   func(obj *kubeObject) []string
+	index *ipIndex
+}
+
+// A ClusterIndex maps the IPs of the cluster's pods and Services to
+// their names. It lists them from the API server once, and then keeps
+// up to date with a watch on each, until we close it.
+type ClusterIndex struct {
+	client        *http.Client
+	url           string
+	authorization string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	lock     sync.Mutex
+	pods     ipIndex
+	services ipIndex
+}
+
+// Find what ip belongs to in the cluster
+func (cluster *ClusterIndex) lookup(ip string) RemoteEndpoint {
+	ip = canonicalIP(ip)
+	if ip == "" {
+		return RemoteEndpoint{}
+	}
+
+	cluster.lock.Lock()
+	defer cluster.lock.Unlock()
+
+	if pod, ok := cluster.pods.ips[ip]; ok {
+		return RemoteEndpoint{namespace: pod.namespace, pod: pod.name}
+	}
+	if service, ok := cluster.services.ips[ip]; ok {
+		return RemoteEndpoint{namespace: service.namespace, service: service.name}
+	}
+	return RemoteEndpoint{}
+}
+
+// Stop watching the API server
+func (cluster *ClusterIndex) close() {
+	cluster.cancel()
+}
+
+func (cluster *ClusterIndex) get(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimSuffix(cluster.url, "/")+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if cluster.authorization != "" {
+		req.Header.Set("Authorization", cluster.authorization)
+	}
+
+	resp, err := cluster.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("API server request for %v failed with HTTP status %v", path, resp.Status)
+	}
+	return resp, nil
+}
+
+// List every object of resource, replace its index with them, and
+// return the list's resource version to start watching from
+func (cluster *ClusterIndex) list(resource watchedResource) (string, error) {
+	ctx, cancel := context.WithTimeout(cluster.ctx, subprocessTimeout)
+	defer cancel()
+
+	resp, err := cluster.get(ctx, resource.path, url.Values{})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var list kubeObjectList
+	err = json.NewDecoder(resp.Body).Decode(&list)
+	if err != nil {
+		return "", fmt.Errorf("Couldn't parse API server list of %v: %v", resource.path, err)
+	}
+
+	index := newIpIndex()
+	for i := range list.Items {
+		obj := &list.Items[i]
+		index.set(objectKey{obj.Metadata.Namespace, obj.Metadata.Name}, resource.ips(obj))
+	}
+
+	cluster.lock.Lock()
+	*resource.index = index
+	cluster.lock.Unlock()
+
+	return list.Metadata.ResourceVersion, nil
+}
+
+// Watch resource from resourceVersion and apply its events to its
+// index, until the API server ends the watch or it fails. Returns the
+// last resource version we saw, or "" if we have to list again.
+func (cluster *ClusterIndex) watch(resource watchedResource, resourceVersion string) (string, error) {
+	query := url.Values{
+		"watch":               {"1"},
+		"resourceVersion":     {resourceVersion},
+		"allowWatchBookmarks": {"true"},
+		"timeoutSeconds":      {fmt.Sprint(int(watchTimeout.Seconds()))},
+	}
+	resp, err := cluster.get(cluster.ctx, resource.path, query)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	events := json.NewDecoder(resp.Body)
+	for {
+		var event kubeWatchEvent
+		err := events.Decode(&event)
+		if err == io.EOF {
+			// The API server ends watches after
+			// timeoutSeconds
+			return resourceVersion, nil
+		}
+		if err != nil {
+			return resourceVersion, err
+		}
+
+		if event.Type == "ERROR" {
+			// Usually 410 Gone, because resourceVersion is
+			// too old to watch from
+			return "", fmt.Errorf("API server watch of %v failed: %s", resource.path, event.Object)
+		}
+
+		var obj kubeObject
+		err = json.Unmarshal(event.Object, &obj)
+		if err != nil {
+			return "", fmt.Errorf("Couldn't parse API server watch event: %v", err)
+		}
+		resourceVersion = obj.Metadata.ResourceVersion
+
+		key := objectKey{obj.Metadata.Namespace, obj.Metadata.Name}
+		cluster.lock.Lock()
+		switch event.Type {
+		case "ADDED", "MODIFIED":
+			resource.index.set(key, resource.ips(&obj))
+		case "DELETED":
+			resource.index.delete(key)
+		}
+		cluster.lock.Unlock()
+	}
+}
+
+// Keep resource's index up to date, listing it again whenever we can't
+// continue a watch, until we close the ClusterIndex
+func (cluster *ClusterIndex) keepWatching(resource watchedResource, resourceVersion string) {
+	for cluster.ctx.Err() == nil {
+		var err error
+		if resourceVersion == "" {
+			resourceVersion, err = cluster.list(resource)
+		} else {
+			resourceVersion, err = cluster.watch(resource, resourceVersion)
+		}
+
+		if err != nil {
+			select {
+			case <-cluster.ctx.Done():
+			case <-time.After(watchRetryDelay):
+			}
+		}
+	}
+}
+
+// List the cluster's pods and Services from the API server that config
+// describes, and start watching them
+func startClusterWatch(config KubeAPIConfig) (*ClusterIndex, error) {
+	client, authorization, err := newKubeAPIClient(config)
+	if err != nil {
+		return nil, err
+	}
+	// Watches last much longer than other requests. list sets its
+	// own timeout.
+	client.Timeout = 0
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cluster := &ClusterIndex{
+		client:        client,
+		url:           config.url,
+		authorization: authorization,
+		ctx:           ctx,
+		cancel:        cancel,
+		pods:          newIpIndex(),
+		services:      newIpIndex(),
+	}
+
+	resources := []watchedResource{
+		{path: "/api/v1/pods", ips: podIPs, index: &cluster.pods},
+		{path: "/api/v1/services", ips: serviceIPs, index: &cluster.services},
+	}
+	for _, resource := range resources {
+		resourceVersion, err := cluster.list(resource)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		go cluster.keepWatching(resource, resourceVersion)
+	}
+
+	return cluster, nil
+}
+
+// Set the remote endpoint of every connection whose remote host is in
+// the cluster
+func attachRemoteEndpoints(connections []KubeConnection, cluster *ClusterIndex) {
+	for i, kc := range connections {
+		connections[i].remote = cluster.lookup(kc.conn.remoteHost)
+	}
+}
+
+// Columns made from a connection's RemoteEndpoint
+var remoteEndpointColumns = []partColumn[RemoteEndpoint]{
+	{"Remote Namespace", func(remote *RemoteEndpoint) string { return remote.namespace }},
+	{"Remote Pod", func(remote *RemoteEndpoint) string { return remote.pod }},
+	{"Remote Service", func(remote *RemoteEndpoint) string { return remote.service }},
+}
+
+// The remoteEndpointColumns, as columns of a table of KubeConnections
+func remoteEndpointConnectionColumns() []kubeConnectionColumn {
+	return partConnectionColumns(remoteEndpointColumns, func(kc *KubeConnection) *RemoteEndpoint { return &kc.remote })
+}
+
+// The remoteEndpointColumns, as columns of a table of ConnectionCounts
+func remoteEndpointCountColumns() []connectionCountColumn {
+	return partCountColumns(remoteEndpointColumns, func(cc *ConnectionCount) *RemoteEndpoint { return &cc.remote })
+}
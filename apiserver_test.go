@@ -0,0 +1,229 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// These should match the format of the API server's pod and Service
+// lists, which have much more in them than this
+const apiServerPods = `{
+  "kind": "PodList",
+  "apiVersion": "v1",
+  "metadata": {"resourceVersion": "1000"},
+  "items": [
+    {
+      "metadata": {"name": "backend-5c6d7e8f9-abcde", "namespace": "my-app", "resourceVersion": "990"},
+      "spec": {"nodeName": "kube-node-2"},
+      "status": {"phase": "Running", "podIP": "10.2.10.82", "podIPs": [{"ip": "10.2.10.82"}, {"ip": "fd00:10:2::52"}]}
+    },
+    {
+      "metadata": {"name": "kube-proxy-abcde", "namespace": "kube-system", "resourceVersion": "991"},
+      "spec": {"nodeName": "kube-node-1", "hostNetwork": true},
+      "status": {"phase": "Running", "podIP": "10.240.0.4"}
+    },
+    {
+      "metadata": {"name": "migrate-h5g6f", "namespace": "my-app", "resourceVersion": "992"},
+      "spec": {"nodeName": "kube-node-1"},
+      "status": {"phase": "Succeeded", "podIP": "10.2.9.80"}
+    }
+  ]
+}`
+
+const apiServerServices = `{
+  "kind": "ServiceList",
+  "apiVersion": "v1",
+  "metadata": {"resourceVersion": "1000"},
+  "items": [
+    {
+      "metadata": {"name": "backend", "namespace": "my-app", "resourceVersion": "993"},
+      "spec": {"clusterIP": "10.0.142.7", "clusterIPs": ["10.0.142.7"]}
+    },
+    {
+      "metadata": {"name": "db", "namespace": "my-app", "resourceVersion": "994"},
+      "spec": {"clusterIP": "None", "clusterIPs": ["None"]}
+    }
+  ]
+}`
+
+// A fake API server that lists apiServerPods and apiServerServices,
+// and streams podEvents to pod watches
+type fakeApiServer struct {
+	token     string
+	podEvents chan string
+}
+
+func (s *fakeApiServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer "+s.token {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var list string
+	switch r.URL.Path {
+	case "/api/v1/pods":
+		list = apiServerPods
+	case "/api/v1/services":
+		list = apiServerServices
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.URL.Query().Get("watch") != "1" {
+		w.Write([]byte(list))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+	for {
+		var event string
+		select {
+		case <-r.Context().Done():
+			return
+		case event = <-s.podEvents:
+		}
+		if r.URL.Path != "/api/v1/pods" {
+			continue
+		}
+		w.Write([]byte(event + "\n"))
+		w.(http.Flusher).Flush()
+	}
+}
+
+func startFakeApiServer(t *testing.T, s *fakeApiServer) KubeAPIConfig {
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	err := os.WriteFile(tokenFile, []byte(s.token+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return KubeAPIConfig{url: server.URL, tokenFile: tokenFile}
+}
+
+func TestIpIndex(t *testing.T) {
+	index := newIpIndex()
+	backend := objectKey{"my-app", "backend"}
+	frontend := objectKey{"my-app", "frontend"}
+
+	index.set(backend, []string{"10.2.10.82", "", "not an IP"})
+	expectEqual(t, index.ips["10.2.10.82"], backend, "Expected the backend's IP")
+	expectEqual(t, len(index.ips), 1, "Expected to skip empty and invalid IPs")
+
+	// The frontend gets the backend's IP before we hear that the
+	// backend lost it
+	index.set(frontend, []string{"10.2.10.82"})
+	index.delete(backend)
+	expectEqual(t, index.ips["10.2.10.82"], frontend, "Deleting the backend shouldn't forget the frontend's IP")
+
+	index.set(frontend, []string{"10.2.9.76"})
+	_, ok := index.ips["10.2.10.82"]
+	expectEqual(t, ok, false, "Expected the frontend's old IP to be forgotten")
+	expectEqual(t, index.ips["10.2.9.76"], frontend, "Expected the frontend's new IP")
+}
+
+func TestClusterWatch(t *testing.T) {
+	server := &fakeApiServer{token: "s3cret", podEvents: make(chan string)}
+	config := startFakeApiServer(t, server)
+
+	cluster, err := startClusterWatch(config)
+	if err != nil {
+		t.Fatalf("Couldn't start watching the fake API server: %v", err)
+	}
+	defer cluster.close()
+
+	backendPod := RemoteEndpoint{namespace: "my-app", pod: "backend-5c6d7e8f9-abcde"}
+	for _, test := range []struct {
+		ip       string
+		expected RemoteEndpoint
+	}{
+		{"10.2.10.82", backendPod},
+		{"::ffff:10.2.10.82", backendPod},
+		{"fd00:10:2::52", backendPod},
+		{"10.0.142.7", RemoteEndpoint{namespace: "my-app", service: "backend"}},
+		// On the host network
+		{"10.240.0.4", RemoteEndpoint{}},
+		// Finished
+		{"10.2.9.80", RemoteEndpoint{}},
+		{"93.184.216.34", RemoteEndpoint{}},
+		{"example.com", RemoteEndpoint{}},
+	} {
+		expectEqual(t, cluster.lookup(test.ip), test.expected, "Unexpected remote endpoint of "+test.ip)
+	}
+
+	server.podEvents <- `{"type": "ADDED", "object": {"metadata": {"name": "frontend-x2x4z", "namespace": "my-app", "resourceVersion": "1001"}, "status": {"phase": "Running", "podIP": "10.2.9.76"}}}`
+	server.podEvents <- `{"type": "DELETED", "object": {"metadata": {"name": "backend-5c6d7e8f9-abcde", "namespace": "my-app", "resourceVersion": "1002"}}}`
+	server.podEvents <- `{"type": "BOOKMARK", "object": {"metadata": {"resourceVersion": "1003"}}}`
+
+	// The watch applies events in the background
+	deadline := time.Now().Add(5 * time.Second)
+	for cluster.lookup("10.2.10.82") != (RemoteEndpoint{}) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	expectEqual(t, cluster.lookup("10.2.9.76"), RemoteEndpoint{namespace: "my-app", pod: "frontend-x2x4z"},
+		"Expected the added frontend pod")
+	expectEqual(t, cluster.lookup("10.2.10.82"), RemoteEndpoint{}, "Expected the deleted backend pod to be gone")
+}
+
+func TestClusterWatchErrors(t *testing.T) {
+	config := startFakeApiServer(t, &fakeApiServer{token: "s3cret"})
+
+	_, err := startClusterWatch(KubeAPIConfig{url: config.url})
+	if err == nil {
+		t.Errorf("Expected an error without a token")
+	}
+
+	_, err = startClusterWatch(KubeAPIConfig{url: config.url, tokenFile: filepath.Join(t.TempDir(), "missing")})
+	if err == nil {
+		t.Errorf("Expected an error with a missing token file")
+	}
+}
+
+func TestAttachRemoteEndpoints(t *testing.T) {
+	config := startFakeApiServer(t, &fakeApiServer{token: "s3cret"})
+	cluster, err := startClusterWatch(config)
+	if err != nil {
+		t.Fatalf("Couldn't start watching the fake API server: %v", err)
+	}
+	defer cluster.close()
+
+	feServer := ContainerPath{"my-app", "frontend", "fe-server", kubernetesKind}
+	connections := []KubeConnection{
+		{conn: Connection{protocol: "tcp", remoteHost: "10.0.142.7", remotePort: "80"}, container: feServer},
+		{conn: Connection{protocol: "tcp", remoteHost: "10.0.142.7", remotePort: "80"}, container: feServer},
+		{conn: Connection{protocol: "tcp", remoteHost: "10.2.10.82", remotePort: "8080"}, container: feServer},
+		{conn: Connection{protocol: "tcp", remoteHost: "93.184.216.34", remotePort: "443"}, container: feServer},
+	}
+	attachRemoteEndpoints(connections, cluster)
+
+	columns := remoteEndpointConnectionColumns()
+	for i, expected := range []string{
+		"my-app  backend",
+		"my-app  backend",
+		"my-app backend-5c6d7e8f9-abcde ",
+		"  ",
+	} {
+		fields := kubeConnectionRow{kc: &connections[i], columns: columns}.Fields()
+		expectEqual(t, strings.Join(fields[len(fields)-len(columns):], " "), expected,
+			"Unexpected remote endpoint fields of "+connections[i].conn.remoteHost)
+	}
+
+	stats := summarizeKubeConnections(connections)
+	expectEqual(t, len(stats), 3, "Unexpected number of summary rows")
+	for _, stat := range stats {
+		if stat.connId.remoteHost == "10.0.142.7" {
+			expectEqual(t, stat.count, 2, "Unexpected count of connections to the backend Service")
+			expectEqual(t, remoteEndpointCountColumns()[2].field(&stat), "backend",
+				"Unexpected remote Service of the summary row")
+		}
+	}
+}
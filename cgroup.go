@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Get the pod UID from the name of a pod's cgroup, or "" if component
+// isn't one. The cgroupfs cgroup driver names them pod<uid>, and the
+// systemd driver names them kubepods-<qos>-pod<uid>.slice, with the
+// dashes in the UID escaped as underscores.
+func podUidFromCgroup(component string) string {
+	if strings.HasSuffix(component, ".slice") {
+		name := strings.TrimSuffix(component, ".slice")
+		i := strings.LastIndex(name, "-pod")
+		if !strings.HasPrefix(name, "kubepods") || i < 0 {
+			return ""
+		}
+
+		return strings.ReplaceAll(name[i+len("-pod"):], "_", "-")
+	}
+
+	if strings.HasPrefix(component, "pod") {
+		return strings.TrimPrefix(component, "pod")
+	}
+
+	return ""
+}
+
+// Get the container ID from the name of a container's cgroup. The
+// cgroupfs driver uses the bare ID, or prefixes it with the runtime,
+// like crio-<id>. The systemd driver makes a scope named after the
+// runtime and the ID, like cri-containerd-<id>.scope or
+// docker-<id>.scope. Container IDs don't contain dashes.
+func containerIdFromCgroup(component string) string {
+	name := strings.TrimSuffix(component, ".scope")
+	return name[strings.LastIndex(name, "-")+1:]
+}
+
+// Parse a cgroup path of a Kubernetes container, and return its pod
+// UID and container ID. Kubelet puts containers in cgroups like
+//
+//	/kubepods/burstable/pod3b8c2a9e-1f4d-4b8e-9a52-6a1e0f3c7d21/0f5c...
+//
+// with the cgroupfs cgroup driver, and like
+//
+//	/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod3b8c2a9e_1f4d_4b8e_9a52_6a1e0f3c7d21.slice/cri-containerd-0f5c....scope
+//
+// with the systemd driver. Guaranteed pods sit directly under the
+// kubepods cgroup. The layout is the same under cgroup v1 and v2.
+// Processes in a pod's cgroup but not in one of its containers'
+// cgroups get an empty container ID.
+//
+// Containers outside Kubernetes are in cgroups like /docker/<id>,
+// /system.slice/docker-<id>.scope or machine.slice/libpod-<id>.scope,
+// and get an empty pod UID. Returns empty strings if path isn't a
+// container's or a pod's cgroup.
+func parseCgroupPath(path string) (podUid string, containerId string) {
+	components := strings.Split(path, "/")
+
+	inKubepods := false
+	for i, component := range components {
+		if component == "kubepods" || component == "kubepods.slice" {
+			inKubepods = true
+			continue
+		}
+		if !inKubepods {
+			continue
+		}
+
+		podUid = podUidFromCgroup(component)
+		if podUid != "" {
+			if i+1 < len(components) && components[i+1] != "" {
+				containerId = containerIdFromCgroup(components[i+1])
+			}
+			return podUid, containerId
+		}
+	}
+
+	for i := len(components) - 1; i >= 0; i-- {
+		containerId = containerIdFromCgroup(components[i])
+		if isContainerId(containerId) {
+			return "", containerId
+		}
+	}
+
+	return "", ""
+}
+
+// Is s a full container ID, which is 64 hex digits?
+func isContainerId(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// Parse a /proc/<pid>/cgroup file, and return the pod UID and container
+// ID of the process. Each line is
+//
+//	hierarchy-ID:controller-list:cgroup-path
+//
+// Under cgroup v1, every hierarchy has a line, and they normally all
+// have the same path. Under cgroup v2 there is one line, with hierarchy
+// ID 0 and no controllers. Hybrid setups have both. We prefer a line
+// with a pod UID, and otherwise use the first line with a container ID.
+func parseProcCgroup(r io.Reader) (podUid string, containerId string, err error) {
+	lines := bufio.NewScanner(r)
+	var firstContainerId string
+	for lines.Scan() {
+		parts := strings.SplitN(lines.Text(), ":", 3)
+		if len(parts) != 3 {
+			return "", "", fmt.Errorf("Couldn't parse cgroup line %v", lines.Text())
+		}
+
+		podUid, containerId = parseCgroupPath(parts[2])
+		if podUid != "" {
+			return podUid, containerId, nil
+		}
+		if firstContainerId == "" {
+			firstContainerId = containerId
+		}
+	}
+
+	return "", firstContainerId, lines.Err()
+}
+
+// The cgroup paths in a /proc/<pid>/cgroup file
+func procCgroupPaths(data []byte) []string {
+	var paths []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) == 3 {
+			paths = append(paths, parts[2])
+		}
+	}
+	return paths
+}
+
+// A PodResolver finds the container that a host PID runs in. It reads
+// the PID's cgroup to get its pod UID and container ID, and looks them
+// up in the containers the runtimes told us about. This works for every
+// process in a container, not just the container's root process.
+type PodResolver struct {
+	procRoot     string                   // Normally "/proc"
+	containers   map[string]ContainerPath // Keyed by container ID
+	infos        map[string]ContainerInfo // Keyed by container ID
+	pods         map[string]ContainerPath // Keyed by pod UID, without container names
+	rootPids     map[int]ContainerPath    // Keyed by the containers' root PIDs
+	rootPidInfos map[int]ContainerInfo    // Keyed by the containers' root PIDs
+	rootStarts   map[int]uint64           // The start times of the root PIDs, as processStartTime returns them
+	static       *StaticMapping           // From a mapping file, or nil
+	cache        map[int]resolvedPid
+
+	// Whether we found a process in a container that the runtimes
+	// didn't tell us about, which means they have new containers
+	missedContainers bool
+}
+
+// The container a PID runs in, and what else we know about it
+type resolvedPid struct {
+	path ContainerPath
+	info ContainerInfo
+}
+
+// The start time of a process, in clock ticks since boot, from field 22
+// of /proc/<pid>/stat. A PID with a different start time than before
+// belongs to a new process.
+func processStartTime(procRoot string, pid int) (uint64, error) {
+	stat, err := os.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return 0, err
+	}
+
+	// The command name in field 2 is in parentheses, and can have
+	// spaces and parentheses in it, so the fields we want start
+	// after the last ")"
+	end := bytes.LastIndexByte(stat, ')')
+	if end < 0 {
+		return 0, fmt.Errorf("Couldn't parse /proc/%d/stat", pid)
+	}
+	fields := strings.Fields(string(stat[end+1:]))
+	if len(fields) < 20 {
+		return 0, fmt.Errorf("Couldn't parse /proc/%d/stat", pid)
+	}
+	return strconv.ParseUint(fields[19], 10, 64)
+}
+
+// Whether the root process of a container the runtimes told us about
+// has exited, or its PID now belongs to another process. That means a
+// container was deleted or restarted, and rootPids is out of date.
+func (resolver *PodResolver) rootPidsChanged() bool {
+	for pid, start := range resolver.rootStarts {
+		now, err := processStartTime(resolver.procRoot, pid)
+		if err != nil || now != start {
+			return true
+		}
+	}
+	return false
+}
+
+func newPodResolver(procRoot string, containers []RuntimeContainer) *PodResolver {
+	resolver := &PodResolver{
+		procRoot:     procRoot,
+		containers:   make(map[string]ContainerPath),
+		infos:        make(map[string]ContainerInfo),
+		pods:         make(map[string]ContainerPath),
+		rootPids:     pidMapFromContainers(containers),
+		rootPidInfos: make(map[int]ContainerInfo),
+		rootStarts:   make(map[int]uint64),
+		cache:        make(map[int]resolvedPid),
+	}
+
+	for _, container := range containers {
+		info := ContainerInfo{podUid: container.podUid, containerId: container.id, image: container.image, attempt: container.attempt}
+		resolver.containers[container.id] = container.kubePath
+		resolver.infos[container.id] = info
+		if container.pid != 0 {
+			resolver.rootPidInfos[container.pid] = info
+			// A root process that is already gone has nothing
+			// to compare with later
+			if start, err := processStartTime(procRoot, container.pid); err == nil {
+				resolver.rootStarts[container.pid] = start
+			}
+		}
+		if container.podUid != "" {
+			resolver.pods[container.podUid] = ContainerPath{
+				PodNamespace: container.kubePath.PodNamespace,
+				PodName:      container.kubePath.PodName,
+				Kind:         container.kubePath.Kind,
+			}
+		}
+	}
+
+	return resolver
+}
+
+// Find the container a particular PID runs in, or return an error
+func (resolver *PodResolver) pidToPod(pid int) (ContainerPath, error) {
+	path, _, err := resolver.pidToContainer(pid)
+	return path, err
+}
+
+// Find the container a particular PID runs in and its ContainerInfo,
+// or return an error
+func (resolver *PodResolver) pidToContainer(pid int) (ContainerPath, ContainerInfo, error) {
+	if resolved, ok := resolver.cache[pid]; ok {
+		return resolved.path, resolved.info, nil
+	}
+
+	path, info, err := resolver.resolve(pid)
+	if err != nil {
+		return ContainerPath{}, ContainerInfo{}, err
+	}
+
+	resolver.cache[pid] = resolvedPid{path, info}
+	return path, info, nil
+}
+
+// Find the container a particular PID runs in. PIDs that aren't in a
+// container we know of are on the host. We don't know the PIDs of
+// some sockets, like TIME_WAIT connections, and return ContainerPath{}
+// for them.
+func (resolver *PodResolver) pidToPodOrHost(pid int) ContainerPath {
+	if pid == 0 {
+		return ContainerPath{}
+	}
+
+	path, err := resolver.pidToPod(pid)
+	if err != nil {
+		return ContainerPath{Kind: hostKind}
+	}
+	return path
+}
+
+// Find the container of a socket from its PID, the inode of its net
+// namespace and its local address, which is "" for Unix sockets.
+// Sockets whose PIDs aren't in a container get the container of their
+// net namespace or local address from the mapping file, if it has one.
+func (resolver *PodResolver) socketToPod(pid int, netns int, localHost string) ContainerPath {
+	path := resolver.pidToPodOrHost(pid)
+	if resolver.static == nil || (path != ContainerPath{} && path.Kind != hostKind) {
+		return path
+	}
+
+	if staticPath, ok := resolver.static.socketPath(netns, localHost); ok {
+		return staticPath
+	}
+	return path
+}
+
+func (resolver *PodResolver) resolve(pid int) (ContainerPath, ContainerInfo, error) {
+	cgroups, err := os.ReadFile(filepath.Join(resolver.procRoot, strconv.Itoa(pid), "cgroup"))
+
+	// The mapping file overrides the runtimes
+	if resolver.static != nil {
+		if path, ok := resolver.static.processPath(pid, procCgroupPaths(cgroups)); ok {
+			return path, ContainerInfo{}, nil
+		}
+	}
+
+	if err == nil {
+		podUid, containerId, err := parseProcCgroup(bytes.NewReader(cgroups))
+		if err != nil {
+			return ContainerPath{}, ContainerInfo{}, err
+		}
+
+		if path, ok := resolver.containers[containerId]; ok && containerId != "" {
+			return path, resolver.infos[containerId], nil
+		}
+		if containerId != "" && (podUid != "" || isContainerId(containerId)) {
+			resolver.missedContainers = true
+		}
+		if path, ok := resolver.pods[podUid]; ok && podUid != "" {
+			return path, ContainerInfo{podUid: podUid}, nil
+		}
+	}
+
+	// The runtimes still tell us their containers' root PIDs, for
+	// processes in cgroups we don't recognize
+	if path, ok := resolver.rootPids[pid]; ok {
+		return path, resolver.rootPidInfos[pid], nil
+	}
+
+	return ContainerPath{}, ContainerInfo{}, fmt.Errorf("Couldn't find the container of PID %d", pid)
+}
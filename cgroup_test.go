@@ -0,0 +1,297 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const (
+	frontendPodUid = "3b8c2a9e-1f4d-4b8e-9a52-6a1e0f3c7d21"
+	feServerId     = "0f5c6e2b9a7d4c3e8f1a2b3c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5e6f70"
+	logShipperId   = "8d3e1f0a2b4c6d8e0f1a3b5c7d9e1f2a4b6c8d0e2f3a5b7c9d1e3f5a7b9c0d2e"
+	webId          = "5b2d9c1e7f3a4b6c8d0e2f4a6b8c0d1e3f5a7b9c1d3e5f7a9b0c2d4e6f8a0b1c"
+	dbId           = "e4f6a8b0c2d4e6f8a0b2c4d6e8f0a1b3c5d7e9f1a3b5c7d9e1f3a5b7c9d0e2f4"
+)
+
+func TestParseCgroupPath(t *testing.T) {
+	podUid, containerId := parseCgroupPath("/kubepods/burstable/pod" + frontendPodUid + "/" + feServerId)
+	expectEqual(t, podUid, frontendPodUid, "Unexpected pod UID from a burstable cgroup")
+	expectEqual(t, containerId, feServerId, "Unexpected container ID from a burstable cgroup")
+
+	podUid, containerId = parseCgroupPath("/kubepods/pod" + frontendPodUid + "/" + feServerId)
+	expectEqual(t, podUid, frontendPodUid, "Unexpected pod UID from a guaranteed cgroup")
+	expectEqual(t, containerId, feServerId, "Unexpected container ID from a guaranteed cgroup")
+
+	podUid, containerId = parseCgroupPath("/kubepods/besteffort/pod" + frontendPodUid)
+	expectEqual(t, podUid, frontendPodUid, "Unexpected pod UID from a pod cgroup")
+	expectEqual(t, containerId, "", "Expected no container ID from a pod cgroup")
+
+	podUid, containerId = parseCgroupPath("/user.slice/user-1000.slice/session-2.scope")
+	expectEqual(t, podUid, "", "Expected no pod UID outside kubepods")
+	expectEqual(t, containerId, "", "Expected no container ID outside kubepods")
+
+	podUid, containerId = parseCgroupPath("/kubepods/burstable/pod" + frontendPodUid + "/crio-" + feServerId)
+	expectEqual(t, containerId, feServerId, "Unexpected container ID from a CRI-O cgroupfs cgroup")
+
+	for _, path := range []string{
+		"/docker/" + feServerId,
+		"/system.slice/docker-" + feServerId + ".scope",
+		"/machine.slice/libpod-" + feServerId + ".scope/container",
+	} {
+		podUid, containerId = parseCgroupPath(path)
+		expectEqual(t, podUid, "", "Expected no pod UID from "+path)
+		expectEqual(t, containerId, feServerId, "Unexpected container ID from "+path)
+	}
+
+	podUid, _ = parseCgroupPath("/podman/pod123")
+	expectEqual(t, podUid, "", "Expected no pod UID from a pod directory outside kubepods")
+}
+
+func TestParseSystemdCgroupPath(t *testing.T) {
+	systemdUid := strings.ReplaceAll(frontendPodUid, "-", "_")
+
+	for _, test := range []struct {
+		path        string
+		containerId string
+	}{
+		{"/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod" + systemdUid + ".slice/cri-containerd-" + feServerId + ".scope", feServerId},
+		{"/kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod" + systemdUid + ".slice/docker-" + feServerId + ".scope", feServerId},
+		{"/kubepods.slice/kubepods-pod" + systemdUid + ".slice/crio-" + feServerId + ".scope/container", feServerId},
+		{"/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod" + systemdUid + ".slice", ""},
+	} {
+		podUid, containerId := parseCgroupPath(test.path)
+		expectEqual(t, podUid, frontendPodUid, "Unexpected pod UID from systemd cgroup "+test.path)
+		expectEqual(t, containerId, test.containerId, "Unexpected container ID from systemd cgroup "+test.path)
+	}
+
+	podUid, _ := parseCgroupPath("/system.slice/containerd.service")
+	expectEqual(t, podUid, "", "Expected no pod UID from a system service")
+
+	podUid, _ = parseCgroupPath("/kubepods.slice/kubepods-burstable.slice")
+	expectEqual(t, podUid, "", "Expected no pod UID from a QoS class slice")
+}
+
+// This should match the format of /proc/<pid>/cgroup under cgroup v1
+const procCgroupV1 = `12:pids:/kubepods/burstable/pod3b8c2a9e-1f4d-4b8e-9a52-6a1e0f3c7d21/0f5c6e2b9a7d4c3e8f1a2b3c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5e6f70
+11:cpu,cpuacct:/kubepods/burstable/pod3b8c2a9e-1f4d-4b8e-9a52-6a1e0f3c7d21/0f5c6e2b9a7d4c3e8f1a2b3c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5e6f70
+1:name=systemd:/kubepods/burstable/pod3b8c2a9e-1f4d-4b8e-9a52-6a1e0f3c7d21/0f5c6e2b9a7d4c3e8f1a2b3c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5e6f70
+`
+
+// This should match the format of /proc/<pid>/cgroup under cgroup v2
+// with the systemd cgroup driver
+const procCgroupV2 = `0::/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod3b8c2a9e_1f4d_4b8e_9a52_6a1e0f3c7d21.slice/cri-containerd-0f5c6e2b9a7d4c3e8f1a2b3c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5e6f70.scope
+`
+
+// In hybrid setups, the v2 line can have a different path than the v1
+// ones
+const procCgroupHybrid = `12:pids:/kubepods/burstable/pod3b8c2a9e-1f4d-4b8e-9a52-6a1e0f3c7d21/0f5c6e2b9a7d4c3e8f1a2b3c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5e6f70
+1:name=systemd:/kubepods/burstable/pod3b8c2a9e-1f4d-4b8e-9a52-6a1e0f3c7d21/0f5c6e2b9a7d4c3e8f1a2b3c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5e6f70
+0::/
+`
+
+func TestParseProcCgroup(t *testing.T) {
+	for _, cgroup := range []string{procCgroupV2, procCgroupHybrid} {
+		podUid, containerId, err := parseProcCgroup(strings.NewReader(cgroup))
+		expectEqual(t, err, nil, "Unexpected error parsing /proc/<pid>/cgroup")
+		expectEqual(t, podUid, frontendPodUid, "Unexpected pod UID from /proc/<pid>/cgroup")
+		expectEqual(t, containerId, feServerId, "Unexpected container ID from /proc/<pid>/cgroup")
+	}
+
+	podUid, containerId, err := parseProcCgroup(strings.NewReader(procCgroupV1))
+	expectEqual(t, err, nil, "Unexpected error parsing /proc/<pid>/cgroup")
+	expectEqual(t, podUid, frontendPodUid, "Unexpected pod UID from /proc/<pid>/cgroup")
+	expectEqual(t, containerId, feServerId, "Unexpected container ID from /proc/<pid>/cgroup")
+
+	// A Docker container outside Kubernetes, under cgroup v1
+	podUid, containerId, err = parseProcCgroup(strings.NewReader("12:pids:/docker/" + webId + "\n1:name=systemd:/docker/" + webId + "\n"))
+	expectEqual(t, err, nil, "Unexpected error parsing a Docker cgroup")
+	expectEqual(t, podUid, "", "Expected no pod UID from a Docker cgroup")
+	expectEqual(t, containerId, webId, "Unexpected container ID from a Docker cgroup")
+
+	podUid, _, err = parseProcCgroup(strings.NewReader("1:name=systemd:/init.scope\n"))
+	expectEqual(t, err, nil, "Unexpected error parsing a host cgroup")
+	expectEqual(t, podUid, "", "Expected no pod UID from a host cgroup")
+
+	_, _, err = parseProcCgroup(strings.NewReader("garbage\n"))
+	if err == nil {
+		t.Errorf("Expected an error from a bad cgroup line")
+	}
+}
+
+// Make a fake /proc with a cgroup v2 cgroup file for each PID in
+// cgroups, and return its path
+func fakeProcCgroups(t *testing.T, cgroups map[int]string) string {
+	procRoot := t.TempDir()
+	for pid, cgroup := range cgroups {
+		dir := filepath.Join(procRoot, strconv.Itoa(pid))
+		err := os.MkdirAll(dir, 0755)
+		if err == nil {
+			err = os.WriteFile(filepath.Join(dir, "cgroup"), []byte("0::"+cgroup+"\n"), 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return procRoot
+}
+
+func TestPodResolver(t *testing.T) {
+	frontendPod := "/kubepods/burstable/pod" + frontendPodUid
+	procRoot := fakeProcCgroups(t, map[int]string{
+		36: frontendPod + "/" + feServerId,
+		37: frontendPod + "/" + feServerId,   // A child of the root process
+		40: frontendPod + "/" + logShipperId, // A container we weren't told about
+		1:  "/init.scope",
+		// Children of the root processes of containers outside
+		// Kubernetes
+		6001: "/system.slice/docker-" + webId + ".scope",
+		7001: "/machine.slice/libpod-" + dbId + ".scope",
+	})
+
+	feServer := ContainerPath{"my-app", "frontend", "fe-server", kubernetesKind}
+	toolbox := ContainerPath{"", "", "toolbox", containerKind}
+	web := ContainerPath{"shop", "", "web", composeKind}
+	db := ContainerPath{"", "", "db", containerKind}
+	resolver := newPodResolver(procRoot, []RuntimeContainer{
+		{id: feServerId, pid: 36, podUid: frontendPodUid, kubePath: feServer},
+		{id: "fab8905c", pid: 5000, kubePath: toolbox},
+		{id: webId, pid: 6000, kubePath: web},
+		{id: dbId, pid: 7000, kubePath: db},
+	})
+
+	for _, test := range []struct {
+		pid      int
+		expected ContainerPath
+	}{
+		{36, feServer},
+		{37, feServer},
+		{40, ContainerPath{PodNamespace: "my-app", PodName: "frontend", Kind: kubernetesKind}},
+		{5000, toolbox},
+		{6001, web},
+		{7001, db},
+	} {
+		path, err := resolver.pidToPod(test.pid)
+		expectEqual(t, err, nil, "Unexpected error resolving a PID")
+		if path != test.expected {
+			t.Errorf("Got %v for PID %v, expected %v", path, test.pid, test.expected)
+		}
+	}
+
+	_, err := resolver.pidToPod(1)
+	if err == nil {
+		t.Errorf("Expected an error for a host process")
+	}
+	expectEqual(t, resolver.pidToPodOrHost(1), ContainerPath{Kind: hostKind}, "Expected PID 1 to be on the host")
+	expectEqual(t, resolver.pidToPodOrHost(0), ContainerPath{}, "Expected no ContainerPath without a PID")
+}
+
+func TestPodResolverContainerInfo(t *testing.T) {
+	frontendPod := "/kubepods/burstable/pod" + frontendPodUid
+	procRoot := fakeProcCgroups(t, map[int]string{
+		37: frontendPod + "/" + feServerId,
+		40: frontendPod + "/" + logShipperId,
+	})
+
+	resolver := newPodResolver(procRoot, []RuntimeContainer{
+		{id: feServerId, pid: 36, podUid: frontendPodUid, attempt: 2, image: "registry.example/frontend:1.2",
+			kubePath: ContainerPath{"my-app", "frontend", "fe-server", kubernetesKind}},
+		{id: "fab8905c", pid: 5000, image: "alpine:3", kubePath: ContainerPath{"", "", "toolbox", containerKind}},
+	})
+
+	feServerInfo := ContainerInfo{podUid: frontendPodUid, containerId: feServerId, image: "registry.example/frontend:1.2", attempt: 2}
+	for _, test := range []struct {
+		pid      int
+		expected ContainerInfo
+	}{
+		{37, feServerInfo},
+		// The root PID, without a cgroup file
+		{36, feServerInfo},
+		// We only know the pod of a container we weren't told about
+		{40, ContainerInfo{podUid: frontendPodUid}},
+		{5000, ContainerInfo{containerId: "fab8905c", image: "alpine:3"}},
+	} {
+		_, info, err := resolver.pidToContainer(test.pid)
+		expectEqual(t, err, nil, "Unexpected error resolving a PID")
+		if info != test.expected {
+			t.Errorf("Got %+v for PID %v, expected %+v", info, test.pid, test.expected)
+		}
+	}
+
+	// The log shipper's container is new to us
+	expectEqual(t, resolver.missedContainers, true, "Expected to notice a container the runtimes didn't list")
+}
+
+func TestPodResolverMissedContainers(t *testing.T) {
+	procRoot := fakeProcCgroups(t, map[int]string{
+		1: "/init.scope",
+		// A Docker container that started after we asked the
+		// runtimes
+		8000: "/system.slice/docker-" + webId + ".scope",
+	})
+	resolver := newPodResolver(procRoot, nil)
+
+	resolver.pidToPodOrHost(1)
+	expectEqual(t, resolver.missedContainers, false, "Host processes aren't containers the runtimes missed")
+	resolver.pidToPodOrHost(8000)
+	expectEqual(t, resolver.missedContainers, true, "Expected to notice a Docker container the runtimes didn't list")
+}
+
+// Write a /proc/<pid>/stat file under procRoot with the start time
+// start. The command name has parentheses and spaces in it, which
+// processStartTime has to skip.
+func writeProcStat(t *testing.T, procRoot string, pid int, start uint64) {
+	dir := filepath.Join(procRoot, strconv.Itoa(pid))
+	stat := fmt.Sprintf("%d (my (odd) cmd) S 1 %d %d 0 -1 4194560 2290 0 0 0 11 7 0 0 20 0 12 0 %d 1234567 300 18446744073709551615\n",
+		pid, pid, pid, start)
+	err := os.MkdirAll(dir, 0755)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, "stat"), []byte(stat), 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProcessStartTime(t *testing.T) {
+	procRoot := t.TempDir()
+	writeProcStat(t, procRoot, 36, 98765)
+	start, err := processStartTime(procRoot, 36)
+	expectEqual(t, err, nil, "Unexpected error reading a start time")
+	expectEqual(t, start, uint64(98765), "Unexpected start time")
+
+	_, err = processStartTime(procRoot, 37)
+	if err == nil {
+		t.Errorf("Expected an error for a process that doesn't exist")
+	}
+
+	_, err = processStartTime("/proc", os.Getpid())
+	expectEqual(t, err, nil, "Couldn't read our own start time")
+}
+
+func TestRootPidsChanged(t *testing.T) {
+	procRoot := t.TempDir()
+	writeProcStat(t, procRoot, 36, 98765)
+	writeProcStat(t, procRoot, 5000, 123456)
+	resolver := newPodResolver(procRoot, []RuntimeContainer{
+		{id: feServerId, pid: 36, kubePath: ContainerPath{"my-app", "frontend", "fe-server", kubernetesKind}},
+		{id: "fab8905c", pid: 5000, kubePath: ContainerPath{"", "", "toolbox", containerKind}},
+		// Already gone when we built the resolver
+		{id: "a01098fd", pid: 6000, kubePath: ContainerPath{"", "", "old", containerKind}},
+	})
+	expectEqual(t, resolver.rootPidsChanged(), false, "Expected the root PIDs to be unchanged")
+
+	// The toolbox was restarted, and its old PID reused
+	writeProcStat(t, procRoot, 5000, 234567)
+	expectEqual(t, resolver.rootPidsChanged(), true, "Expected to notice a reused root PID")
+
+	writeProcStat(t, procRoot, 5000, 123456)
+	err := os.RemoveAll(filepath.Join(procRoot, "36"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectEqual(t, resolver.rootPidsChanged(), true, "Expected to notice a root process that exited")
+}
@@ -7,7 +7,6 @@ package main
 // matching what my version of Kubelet does.
 
 import (
-	"bufio"
 	"flag"
 	"fmt"
 	"os"
@@ -18,14 +17,17 @@ import (
 
 // A connection with a Kubernetes pod identifier instead of a PID
 type KubeConnection struct {
-	conn      Connection
-	container ContainerPath
+	conn       Connection
+	container  ContainerPath
+	remoteName string         // The remote host's DNS name, if we looked it up
+	pod        *PodInfo       // What kubelet told us about the pod, if we asked
+	remote     RemoteEndpoint // The remote host's pod or Service, if we looked it up
+	peer       ContainerPath  // The container at the other end, if it's on this node and we looked for it
+	info       ContainerInfo  // The container's pod UID, ID and image, if we asked
 }
 
 const subprocessTimeout = 5 * time.Second
 
-const ppidColon string = "PPid:"
-
 // How we format our output
 type Format int
 const (
@@ -33,66 +35,41 @@ const (
 	jsonFormat
 )
 
-// Either return the parent PID of its argument, or an error
-func parentOfPid(pid int) (int, error) {
-	fp, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
-	if err != nil {
-		return 0, err
-	}
-	defer fp.Close()
-
-	lines := bufio.NewScanner(fp)
-	for lines.Scan() {
-		line := lines.Text()
-		if strings.HasPrefix(line, ppidColon) {
-			pid, err := strconv.Atoi(line[len(ppidColon):])
-			if err != nil {
-				return 0, err
-			}
-
-			return pid, nil
-		}
-	}
-
-	return 0, fmt.Errorf("Couldn't find parent PID of PID %d", pid)
-}
-
-// Find the container a particular PID runs in, or return an error
-func pidToPod(pid int, pidMap map[int]ContainerPath) (ContainerPath, error) {
-	// Remember the ancestors of this PID in case we have to
-	// search a process hierarchy
-	var ancestors []int
-	for {
-		kube_path, ok := pidMap[pid]
-		if ok {
-			// If we had to search for parents of the
-			// original pid, update the map so we won't
-			// have to do that again
-			for process, _ := range ancestors {
-				pidMap[process] = kube_path
-			}
+// Where we get connections from
+type Backend int
+const (
+	procBackend Backend = iota
+	netstatBackend
+	netlinkBackend
+)
 
-			return kube_path, nil
-		}
+// Whether we show servers (listening TCP sockets and unconnected UDP
+// sockets), like netstat's --listening and --all options
+type ServerMode int
+const (
+	withoutServers ServerMode = iota
+	onlyServers
+	withServers
+)
 
-		ancestors = append(ancestors, pid)
-		var err error
-		pid, err = parentOfPid(pid)
-		if err != nil {
-			return ContainerPath{}, err
-		}
+// Should a table of connections in this mode include conn?
+func (mode ServerMode) includes(conn Connection) bool {
+	switch mode {
+	case onlyServers:
+		return conn.isServer()
+	case withServers:
+		return true
+	default:
+		return !conn.isServer()
 	}
 }
 
 // Map connections with PIDs into KubeConnections with container identifiers
-func getKubeConnections(connections []Connection, pidMap map[int]ContainerPath) []KubeConnection {
+func getKubeConnections(connections []Connection, resolver *PodResolver) []KubeConnection {
 	kubeConnections := make([]KubeConnection, len(connections))
 
 	for i, conn := range connections {
-		pid := conn.pid
-		path, _ := pidToPod(pid, pidMap)
-		// If pidToPod returns an error, then path will be
-		// ContainerPath{}, which is what we want
+		path := resolver.socketToPod(conn.pid, conn.netns, conn.localHost)
 
 		kubeConnections[i] = KubeConnection{
 			conn:      conn,
@@ -103,38 +80,98 @@ func getKubeConnections(connections []Connection, pidMap map[int]ContainerPath)
 	return kubeConnections
 }
 
+// Set the ContainerInfo of every connection from a container the
+// runtimes told us about
+func attachContainerInfo(connections []KubeConnection, resolver *PodResolver) {
+	for i, kc := range connections {
+		if kc.conn.pid == 0 {
+			continue
+		}
+		_, info, err := resolver.pidToContainer(kc.conn.pid)
+		if err == nil {
+			connections[i].info = info
+		}
+	}
+}
+
+// Return the connections whose states are in states. If states is
+// empty, return all connections.
+func filterConnectionStates(connections []Connection, states []string) []Connection {
+	if len(states) == 0 {
+		return connections
+	}
+
+	var result []Connection
+	for _, conn := range connections {
+		for _, state := range states {
+			if conn.connectionState == state {
+				result = append(result, conn)
+				break
+			}
+		}
+	}
+
+	return result
+}
+
 // Like the TCP 4-tuple, but with a ContainerPath for the local side
 type KubeConnectionId struct {
 	container  ContainerPath
+	info       ContainerInfo // Empty unless we show it, so that it only splits rows when we do
+	protocol   string        // "tcp" or "udp", without distinguishing IPv6
+	listenPort string        // The local port of servers, and "" for other connections
 	remoteHost string
 	remotePort string
 }
 
 type ConnectionCount struct {
-	connId KubeConnectionId
-	count  int
+	connId      KubeConnectionId
+	count       int
+	totalQueued int            // Bytes in the receive and send queues of all connections
+	maxQueued   int            // Bytes in the receive and send queues of the fullest connection
+	remoteName  string         // The remote host's DNS name, if we looked it up
+	pod         *PodInfo       // What kubelet told us about the pod, if we asked
+	remote      RemoteEndpoint // The remote host's pod or Service, if we looked it up
+	peer        ContainerPath  // The container at the other end, if it's on this node and we looked for it
 }
 
 func summarizeKubeConnections(connections []KubeConnection) []ConnectionCount {
-	stats := make(map[KubeConnectionId]int)
+	stats := make(map[KubeConnectionId]*ConnectionCount)
 
 	for _, conn := range connections {
 		connId := KubeConnectionId{container: conn.container,
+			info:       conn.info,
+			protocol:   strings.TrimSuffix(conn.conn.protocol, "6"),
 			remoteHost: conn.conn.remoteHost,
 			remotePort: conn.conn.remotePort}
-		count, ok := stats[connId]
+		// Servers have no remote endpoint, so count them by
+		// the port they listen on instead
+		if conn.conn.isServer() {
+			connId.listenPort = conn.conn.localPort
+		}
+		stat, ok := stats[connId]
+		if !ok {
+			stat = &ConnectionCount{connId: connId, remoteName: conn.remoteName, pod: conn.pod, remote: conn.remote}
+			stats[connId] = stat
+		}
 
-		if ok {
-			stats[connId] = count + 1
-		} else {
-			stats[connId] = 1
+		// Connections to one remote host and port all go to
+		// the same peer, but we may only have found the peer
+		// of some of them
+		if stat.peer == (ContainerPath{}) {
+			stat.peer = conn.peer
 		}
+
+		queued := conn.conn.recvQ + conn.conn.sendQ
+		stat.count += 1
+		stat.totalQueued += queued
+		stat.maxQueued = max(stat.maxQueued, queued)
 	}
 
 	result := make([]ConnectionCount, len(stats))
 	index := 0
-	for k, v := range stats {
-		result[index] = ConnectionCount{k, v}
+	for _, v := range stats {
+		result[index] = *v
 		index += 1
 	}
 
@@ -142,7 +179,8 @@ func summarizeKubeConnections(connections []KubeConnection) []ConnectionCount {
 }
 
 var connectionStatFields = []string{
-	"Namespace", "Pod", "Container", "Remote Host", "Remote Port", "Count",
+	"Namespace", "Pod", "Container", "Kind", "Protocol", "Remote Host", "Remote Port", "Count",
+	"Total Queued", "Max Queued",
 }
 
 func (cc ConnectionCount) Fields() []string {
@@ -150,24 +188,180 @@ func (cc ConnectionCount) Fields() []string {
 		cc.connId.container.PodNamespace,
 		cc.connId.container.PodName,
 		cc.connId.container.ContainerName,
+		cc.connId.container.Kind,
+		cc.connId.protocol,
 		cc.connId.remoteHost,
 		cc.connId.remotePort,
 		strconv.Itoa(cc.count),
+		strconv.Itoa(cc.totalQueued),
+		strconv.Itoa(cc.maxQueued),
+	}
+}
+
+// An extra column to print at the end of a table of ConnectionCounts
+type connectionCountColumn struct {
+	header string
+	field  func(cc *ConnectionCount) string
+}
+
+var listenPortColumn = connectionCountColumn{
+	header: "Listen Port",
+	field: func(cc *ConnectionCount) string {
+		return cc.connId.listenPort
+	},
+}
+
+var remoteNameCountColumn = connectionCountColumn{
+	header: "Remote Name",
+	field: func(cc *ConnectionCount) string {
+		return cc.remoteName
+	},
+}
+
+// The ContainerInfo columns of a table of ConnectionCounts. kubelet's
+// columns have a Pod UID too, so we can leave ours out.
+func containerInfoCountColumns(withPodUid bool) []connectionCountColumn {
+	var columns []connectionCountColumn
+	if withPodUid {
+		columns = append(columns, connectionCountColumn{"Pod UID", func(cc *ConnectionCount) string { return cc.connId.info.podUid }})
 	}
+	return append(columns,
+		connectionCountColumn{"Container ID", func(cc *ConnectionCount) string { return cc.connId.info.containerId }},
+		connectionCountColumn{"Image", func(cc *ConnectionCount) string { return cc.connId.info.image }},
+		connectionCountColumn{"Restarts", func(cc *ConnectionCount) string { return cc.connId.info.restarts() }},
+	)
+}
+
+// A ConnectionCount followed by some extra columns
+type connectionCountRow struct {
+	cc      *ConnectionCount
+	columns []connectionCountColumn
+}
+
+func (row connectionCountRow) Fields() []string {
+	fields := row.cc.Fields()
+	for _, column := range row.columns {
+		fields = append(fields, column.field(row.cc))
+	}
+	return fields
 }
 
 var kubeConnectionHeaders = []string{
-	"Namespace", "Pod", "Container", "Protocol",
+	"Namespace", "Pod", "Container", "Kind", "Protocol", "Recv-Q", "Send-Q",
 	"Local Host", "Local Port", "Remote Host", "Remote Port",
 	"Connection State",
 }
 
+// An extra column to print at the end of a table of KubeConnections
+type kubeConnectionColumn struct {
+	header string
+	field  func(kc *KubeConnection) string
+}
+
+// Format one of the statistics in a connection's TCPInfo, or return ""
+// if the connection doesn't have one
+func tcpInfoField(stat func(info *TCPInfo) uint64) func(kc *KubeConnection) string {
+	return func(kc *KubeConnection) string {
+		if kc.conn.tcpInfo == nil {
+			return ""
+		}
+		return strconv.FormatUint(stat(kc.conn.tcpInfo), 10)
+	}
+}
+
+var tcpInfoColumns = []kubeConnectionColumn{
+	{"RTT (us)", tcpInfoField(func(info *TCPInfo) uint64 { return uint64(info.rtt) })},
+	{"Retransmits", tcpInfoField(func(info *TCPInfo) uint64 { return uint64(info.retransmits) })},
+	{"Cwnd", tcpInfoField(func(info *TCPInfo) uint64 { return uint64(info.sndCwnd) })},
+	{"Bytes Acked", tcpInfoField(func(info *TCPInfo) uint64 { return info.bytesAcked })},
+	{"Bytes Received", tcpInfoField(func(info *TCPInfo) uint64 { return info.bytesReceived })},
+}
+
+var remoteNameColumn = kubeConnectionColumn{
+	header: "Remote Name",
+	field: func(kc *KubeConnection) string {
+		return kc.remoteName
+	},
+}
+
+// The ContainerInfo columns of a table of KubeConnections, like
+// containerInfoCountColumns
+func containerInfoConnectionColumns(withPodUid bool) []kubeConnectionColumn {
+	var columns []kubeConnectionColumn
+	if withPodUid {
+		columns = append(columns, kubeConnectionColumn{"Pod UID", func(kc *KubeConnection) string { return kc.info.podUid }})
+	}
+	return append(columns,
+		kubeConnectionColumn{"Container ID", func(kc *KubeConnection) string { return kc.info.containerId }},
+		kubeConnectionColumn{"Image", func(kc *KubeConnection) string { return kc.info.image }},
+		kubeConnectionColumn{"Restarts", func(kc *KubeConnection) string { return kc.info.restarts() }},
+	)
+}
+
+// A KubeConnection followed by some extra columns
+type kubeConnectionRow struct {
+	kc      *KubeConnection
+	columns []kubeConnectionColumn
+}
+
+func (row kubeConnectionRow) Fields() []string {
+	fields := row.kc.Fields()
+	for _, column := range row.columns {
+		fields = append(fields, column.field(row.kc))
+	}
+	return fields
+}
+
+// A column made from one part of a connection that both KubeConnections
+// and ConnectionCounts have, like its PodInfo or its RemoteEndpoint
+type partColumn[T any] struct {
+	header string
+	field  func(part *T) string
+}
+
+// The columns, as columns of a table of KubeConnections. part finds
+// the connection's part, or returns nil if it has none, which gives
+// empty fields.
+func partConnectionColumns[T any](columns []partColumn[T], part func(kc *KubeConnection) *T) []kubeConnectionColumn {
+	var result []kubeConnectionColumn
+	for _, column := range columns {
+		field := column.field
+		result = append(result, kubeConnectionColumn{column.header, func(kc *KubeConnection) string {
+			p := part(kc)
+			if p == nil {
+				return ""
+			}
+			return field(p)
+		}})
+	}
+	return result
+}
+
+// The columns, as columns of a table of ConnectionCounts
+func partCountColumns[T any](columns []partColumn[T], part func(cc *ConnectionCount) *T) []connectionCountColumn {
+	var result []connectionCountColumn
+	for _, column := range columns {
+		field := column.field
+		result = append(result, connectionCountColumn{column.header, func(cc *ConnectionCount) string {
+			p := part(cc)
+			if p == nil {
+				return ""
+			}
+			return field(p)
+		}})
+	}
+	return result
+}
+
 func (kc KubeConnection) Fields() []string {
 	return []string{
 		kc.container.PodNamespace,
 		kc.container.PodName,
 		kc.container.ContainerName,
+		kc.container.Kind,
 		kc.conn.protocol,
+		strconv.Itoa(kc.conn.recvQ),
+		strconv.Itoa(kc.conn.sendQ),
 		kc.conn.localHost,
 		kc.conn.localPort,
 		kc.conn.remoteHost,
@@ -178,16 +372,78 @@ func (kc KubeConnection) Fields() []string {
 
 // CnetstatConfig holds our command-line arguments
 type CnetstatConfig struct {
-	outputFormat Format
-	summaryStats bool
+	outputFormat  Format
+	summaryStats  bool
+	backend       Backend
+	states        []string // Only show connections in these states. Empty means all
+	protocols     []string // "tcp" and/or "udp"
+	serverMode    ServerMode
+	unixSockets   bool     // List Unix domain sockets instead of connections
+	tcpInfo       bool     // Show per-connection TCP statistics
+	netnsDirs     []string // Directories to look for pinned namespaces in
+	numeric       bool     // Show IP addresses and port numbers instead of names
+	remoteNames   bool     // Look up DNS names of remote hosts
+	dnsTimeout    time.Duration
+	runtime       Runtime
+	criSocket     string // The CRI runtime's gRPC socket. Empty means the runtime's default
+	kubeletPods   bool   // Ask kubelet for pod metadata
+	kubelet       KubeAPIConfig
+	groupBy       GroupBy
+	remotePods    bool // Look up the pods and Services of remote hosts in the API server
+	apiServer     KubeAPIConfig
+	peers         bool          // Match both ends of connections within this node
+	mappingFile   string        // A file mapping PIDs, cgroups, net namespaces and IPs to containers
+	containerInfo bool          // Show each container's pod UID, ID and image
+	interval      time.Duration // How often to print a snapshot. 0 means just once
+	count         int           // How many snapshots to print. 0 means no limit
 }
 
 // Parse our arguments
 func parseArgs() (CnetstatConfig, error) {
 	var config CnetstatConfig
 	var formatStr string
+	var backendStr string
+	var statesStr string
+	var protocolsStr string
+	var listening, all bool
+	var cniNetnsDirsStr string
+	var runtimeStr string
+	var groupByStr string
 
 	flag.StringVar(&formatStr, "format", "table", "Output format. Either 'table' or 'json'")
+	flag.StringVar(&backendStr, "backend", "proc", "Where to get connections from. One of 'proc' (read /proc/net), 'netlink' (query NETLINK_SOCK_DIAG) or 'netstat' (run nsenter and netstat)")
+	flag.StringVar(&statesStr, "state", "", "Only show connections in these states, separated by commas, like 'TIME_WAIT,CLOSE_WAIT'")
+	flag.StringVar(&protocolsStr, "protocols", "tcp", "Protocols to show connections of, separated by commas. Either or both of 'tcp' and 'udp'")
+	flag.BoolVar(&listening, "listening", false, "Only show servers (listening TCP sockets and unconnected UDP sockets)")
+	flag.BoolVar(&all, "all", false, "Show servers as well as connections")
+	flag.BoolVar(&config.unixSockets, "unix", false, "List Unix domain sockets instead of TCP and UDP connections. Always lists every socket, ignoring --summaryStatistics")
+	flag.BoolVar(&config.tcpInfo, "tcp-info", false, "Show RTT, retransmits, congestion window and bytes acked and received for each TCP connection. Needs --backend=netlink and --summaryStatistics=false")
+	flag.StringVar(&cniNetnsDirsStr, "cni-netns-dirs", "", "Directories, separated by commas, where CNI plugins or container runtimes pin net namespaces, like '/var/run/docker/netns'. "+strings.Join(defaultNetnsDirs, " and ")+" are always searched")
+	flag.BoolVar(&config.numeric, "numeric", true, "Show IP addresses and port numbers. Use --numeric=false to resolve them to host and service names, which can be slow")
+	flag.BoolVar(&config.remoteNames, "remote-names", false, "Add a 'Remote Name' column with the DNS name of each remote host")
+	flag.DurationVar(&config.dnsTimeout, "dns-timeout", time.Second, "How long to wait for each reverse DNS lookup")
+	flag.StringVar(&runtimeStr, "runtime", "auto", "Container runtime to ask for the containers on this node. One of 'docker', 'containerd', 'cri-o', 'podman', 'auto' (every runtime whose socket exists) or 'none' (only use --mapping-file)")
+	flag.StringVar(&config.mappingFile, "mapping-file", "", "A JSON file that maps PIDs, cgroup paths, net namespace inodes or IP CIDRs to containers, in addition to what the runtimes say. CIDR entries need --numeric. See README.md for its format")
+	flag.StringVar(&config.criSocket, "cri-socket", "", "The CRI gRPC socket to use with --runtime=containerd or --runtime=cri-o, if it isn't "+defaultContainerdSocket+" or "+defaultCrioSocket)
+	flag.BoolVar(&config.containerInfo, "container-info", false, "Add 'Pod UID', 'Container ID', 'Image' and 'Restarts' columns from the container runtime, which tell apart pods and containers recreated with the same names")
+	flag.BoolVar(&config.kubeletPods, "kubelet", false, "Ask kubelet for each pod's UID, labels, node, IP and owner, and add them as columns")
+	flag.StringVar(&config.kubelet.url, "kubelet-url", defaultKubeletUrl, "Where to reach kubelet's API with --kubelet")
+	flag.StringVar(&config.kubelet.tokenFile, "kubelet-token-file", defaultServiceAccountTokenFile, "A file with a bearer token to authenticate to kubelet with")
+	flag.StringVar(&config.kubelet.clientCert, "kubelet-client-cert", "", "A client certificate to authenticate to kubelet with, instead of a token. Needs --kubelet-client-key")
+	flag.StringVar(&config.kubelet.clientKey, "kubelet-client-key", "", "The key of --kubelet-client-cert")
+	flag.StringVar(&config.kubelet.caFile, "kubelet-ca", "", "The CA certificate that signed kubelet's serving certificate. By default, use the system's CAs")
+	flag.BoolVar(&config.kubelet.insecure, "kubelet-insecure-tls", false, "Don't verify kubelet's serving certificate")
+	flag.StringVar(&groupByStr, "group-by", "pod", "What summary statistics count connections of. Either 'pod' or 'workload' (the Deployment, StatefulSet, DaemonSet, CronJob or other controller that owns each pod). 'workload' needs --kubelet, and asks the API server who owns ReplicaSets and Jobs if it can reach it")
+	flag.BoolVar(&config.remotePods, "remote-pods", false, "Watch the Kubernetes API server for pods and Services, and add 'Remote Namespace', 'Remote Pod' and 'Remote Service' columns for remote hosts that are pod IPs or ClusterIPs. Needs --numeric")
+	flag.StringVar(&config.apiServer.url, "apiserver-url", inClusterApiServerUrl(), "Where to reach the Kubernetes API server with --remote-pods or --group-by=workload. By default, use the in-cluster address when cnetstat runs in a pod")
+	flag.StringVar(&config.apiServer.tokenFile, "apiserver-token-file", defaultServiceAccountTokenFile, "A file with a bearer token to authenticate to the API server with")
+	flag.StringVar(&config.apiServer.clientCert, "apiserver-client-cert", "", "A client certificate to authenticate to the API server with, instead of a token. Needs --apiserver-client-key")
+	flag.StringVar(&config.apiServer.clientKey, "apiserver-client-key", "", "The key of --apiserver-client-cert")
+	flag.StringVar(&config.apiServer.caFile, "apiserver-ca", defaultServiceAccountCaFile, "The CA certificate that signed the API server's serving certificate")
+	flag.BoolVar(&config.apiServer.insecure, "apiserver-insecure-tls", false, "Don't verify the API server's serving certificate")
+	flag.BoolVar(&config.peers, "peers", false, "Match up both ends of connections between sockets on this node, and add 'Peer Namespace', 'Peer Pod', 'Peer Container' and 'Peer Kind' columns for the container at the other end. Needs --numeric")
+	flag.DurationVar(&config.interval, "interval", 0, "Print a new snapshot this often, like '5s', with a timestamp, until interrupted. 0 prints one snapshot")
+	flag.IntVar(&config.count, "count", 0, "With --interval, stop after this many snapshots")
 	flag.BoolVar(&config.summaryStats, "summaryStatistics", true, "Print summary statistics rather than all connections")
 
 	flag.Parse()
@@ -209,44 +465,200 @@ func parseArgs() (CnetstatConfig, error) {
 		return config, fmt.Errorf("unrecognized format %v", formatStr)
 	}
 
-	return config, nil
-}
+	switch backendStr {
+	case "proc":
+		config.backend = procBackend
+	case "netstat":
+		config.backend = netstatBackend
+	case "netlink":
+		config.backend = netlinkBackend
+	default:
+		flag.Usage()
+		return config, fmt.Errorf("unrecognized backend %v", backendStr)
+	}
 
-// This is effectively main, but moving it to a separate function
-// makes the error handling simpler
-func cnetstat() error {
-	config, err := parseArgs()
-	if err != nil {
-		return err
+	switch runtimeStr {
+	case "auto":
+		config.runtime = autoRuntime
+	case "docker":
+		config.runtime = dockerRuntime
+	case "containerd":
+		config.runtime = containerdRuntime
+	case "cri-o":
+		config.runtime = crioRuntime
+	case "podman":
+		config.runtime = podmanRuntime
+	case "none":
+		config.runtime = noRuntime
+	default:
+		flag.Usage()
+		return config, fmt.Errorf("unrecognized runtime %v", runtimeStr)
 	}
 
-	// It would be possible to run as non-root and return less
-	// information, but that makes the netstat parsing more
-	// complicated (since netstat will also print a warning
-	// message), and for our use-case we really want all the data,
-	// so just run it as root.
-	if os.Geteuid() != 0 {
-		return fmt.Errorf("cnetstat must run as root")
+	if statesStr != "" {
+		config.states = strings.Split(statesStr, ",")
+		_, err := tcpStateMask(config.states)
+		if err != nil {
+			flag.Usage()
+			return config, err
+		}
 	}
 
-	namespaces, err := listNetNamespaces()
-	if err != nil {
-		return err
+	config.protocols = strings.Split(protocolsStr, ",")
+	for _, protocol := range config.protocols {
+		if protocol != "tcp" && protocol != "udp" {
+			flag.Usage()
+			return config, fmt.Errorf("unrecognized protocol %v", protocol)
+		}
 	}
 
-	pidMap, err := buildPidMap()
-	if err != nil {
+	switch {
+	case listening && all:
+		flag.Usage()
+		return config, fmt.Errorf("--listening and --all can't be used together")
+	case listening:
+		config.serverMode = onlyServers
+	case all:
+		config.serverMode = withServers
+	}
+
+	reverseDNS.timeout = config.dnsTimeout
+
+	config.netnsDirs = defaultNetnsDirs
+	if cniNetnsDirsStr != "" {
+		config.netnsDirs = append(config.netnsDirs, strings.Split(cniNetnsDirsStr, ",")...)
+	}
+
+	switch groupByStr {
+	case "pod":
+		config.groupBy = groupByPod
+	case "workload":
+		config.groupBy = groupByWorkload
+	default:
+		flag.Usage()
+		return config, fmt.Errorf("unrecognized --group-by %v", groupByStr)
+	}
+
+	if config.groupBy == groupByWorkload && !config.kubeletPods {
+		flag.Usage()
+		return config, fmt.Errorf("--group-by=workload needs --kubelet")
+	}
+
+	if (config.kubelet.clientCert == "") != (config.kubelet.clientKey == "") {
+		flag.Usage()
+		return config, fmt.Errorf("--kubelet-client-cert and --kubelet-client-key must be used together")
+	}
+
+	if (config.apiServer.clientCert == "") != (config.apiServer.clientKey == "") {
+		flag.Usage()
+		return config, fmt.Errorf("--apiserver-client-cert and --apiserver-client-key must be used together")
+	}
+
+	if config.remotePods && config.apiServer.url == "" {
+		flag.Usage()
+		return config, fmt.Errorf("--remote-pods needs --apiserver-url outside a pod")
+	}
+
+	if config.remotePods && !config.numeric {
+		flag.Usage()
+		return config, fmt.Errorf("--remote-pods needs --numeric")
+	}
+
+	if config.peers && !config.numeric {
+		flag.Usage()
+		return config, fmt.Errorf("--peers needs --numeric")
+	}
+
+	if config.containerInfo && config.groupBy == groupByWorkload {
+		flag.Usage()
+		return config, fmt.Errorf("--container-info and --group-by=workload can't be used together")
+	}
+
+	if config.interval < 0 || config.count < 0 {
+		flag.Usage()
+		return config, fmt.Errorf("--interval and --count can't be negative")
+	}
+
+	if config.count > 0 && config.interval == 0 {
+		flag.Usage()
+		return config, fmt.Errorf("--count needs --interval")
+	}
+
+	if config.runtime == noRuntime && config.mappingFile == "" {
+		flag.Usage()
+		return config, fmt.Errorf("--runtime=none needs --mapping-file")
+	}
+
+	if config.tcpInfo && config.backend != netlinkBackend {
+		flag.Usage()
+		return config, fmt.Errorf("--tcp-info needs --backend=netlink")
+	}
+
+	if config.tcpInfo && config.summaryStats {
+		flag.Usage()
+		return config, fmt.Errorf("--tcp-info needs --summaryStatistics=false")
+	}
+
+	return config, nil
+}
+
+// Handle an error getting the sockets of namespace. Pods come and go
+// between listing namespaces and reading them, so when we print
+// snapshots periodically, we report the error and carry on without
+// the namespace. Returns err if we should stop instead.
+func namespaceError(config CnetstatConfig, namespace NamespaceData, err error) error {
+	if config.interval == 0 {
 		return err
 	}
 
+	fmt.Fprintf(os.Stderr, "Skipping net namespace %v: %v\n", namespace.Ns, err)
+	return nil
+}
+
+// Get the connections from every namespace in namespaces, using the
+// backend and filters in config
+func collectConnections(config CnetstatConfig, namespaces []NamespaceData) ([]Connection, error) {
+	// The proc and netlink backends find the PID of each
+	// connection from its socket inode, and one map of inodes
+	// works for all namespaces
+	var socketOwners map[uint64]int
+	if config.backend == procBackend || config.backend == netlinkBackend {
+		var err error
+		socketOwners, err = socketInodeOwners("/proc")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// parseArgs has already checked the states
+	stateMask, _ := tcpStateMask(config.states)
+
 	// connections has one slice of Connections for each namespace
 	var connections = make([][]Connection, len(namespaces))
 	for i, namespace := range namespaces {
-		conns, err := getConnectionsFromNamespace(strconv.Itoa(namespace.Pid))
+		var conns []Connection
+		var err error
+		switch config.backend {
+		case procBackend:
+			conns, err = getProcConnectionsFromNamespace(namespace.nsPath(), socketOwners, config.protocols, config.serverMode)
+			conns = filterConnectionStates(conns, config.states)
+		case netstatBackend:
+			conns, err = getConnectionsFromNamespace(namespace.nsPath(), config.protocols, config.serverMode, config.numeric)
+			conns = filterConnectionStates(conns, config.states)
+		case netlinkBackend:
+			conns, err = getNetlinkConnectionsFromNamespace(namespace.nsPath(), socketOwners, config.protocols, stateMask, config.serverMode, config.tcpInfo)
+		}
 		if err != nil {
-			return err
+			err = namespaceError(config, namespace, err)
+			if err != nil {
+				return nil, err
+			}
+			continue
 		}
 
+		for j := range conns {
+			conns[j].netns = namespace.Ns
+		}
 		connections[i] = conns
 	}
 
@@ -265,30 +677,226 @@ func cnetstat() error {
 		offset += len(conns)
 	}
 
-	kubeConnections := getKubeConnections(allConnections, pidMap)
+	// netstat resolves names itself, but we have to do it for the
+	// other backends
+	if !config.numeric && config.backend != netstatBackend {
+		services, err := loadServices()
+		if err != nil {
+			return nil, err
+		}
+
+		resolveConnectionNames(allConnections, services, reverseDNS)
+	}
+
+	return allConnections, nil
+}
+
+// Get TCP and UDP connections from namespaces, and build the table of
+// them to print, with its column headers. cluster is nil unless we
+// look up remote pods and Services.
+func connectionTable(config CnetstatConfig, namespaces []NamespaceData, resolver *PodResolver, cluster *ClusterIndex) ([]Fielder, []string, error) {
+	allConnections, err := collectConnections(config, namespaces)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	kubeConnections := getKubeConnections(allConnections, resolver)
 	println("Got", len(kubeConnections), "kubeConnections")
 
+	if config.remoteNames {
+		resolveRemoteNames(kubeConnections, reverseDNS)
+	}
+
+	if config.containerInfo {
+		attachContainerInfo(kubeConnections, resolver)
+	}
+
+	if config.kubeletPods {
+		pods, err := fetchKubeletPods(config.kubelet)
+		if err != nil {
+			return nil, nil, err
+		}
+		if config.groupBy == groupByWorkload && config.apiServer.url != "" {
+			err = fetchWorkloadOwners(config.apiServer, pods)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		attachPodInfo(kubeConnections, pods)
+	}
+
+	if cluster != nil {
+		attachRemoteEndpoints(kubeConnections, cluster)
+	}
+
+	if config.peers {
+		joinPeers(kubeConnections)
+	}
+
 	var table []Fielder
 	var columns []string
 	if config.summaryStats {
+		var extraColumns []connectionCountColumn
+		if config.serverMode != withoutServers {
+			extraColumns = append(extraColumns, listenPortColumn)
+		}
+		if config.remoteNames {
+			extraColumns = append(extraColumns, remoteNameCountColumn)
+		}
+		if config.kubeletPods && config.groupBy == groupByPod {
+			extraColumns = append(extraColumns, podInfoCountColumns()...)
+		}
+		if config.containerInfo {
+			extraColumns = append(extraColumns, containerInfoCountColumns(!config.kubeletPods)...)
+		}
+		if cluster != nil {
+			extraColumns = append(extraColumns, remoteEndpointCountColumns()...)
+		}
+		if config.peers {
+			extraColumns = append(extraColumns, peerCountColumns()...)
+		}
+
+		if config.groupBy == groupByWorkload {
+			rollUpToWorkloads(kubeConnections)
+		}
+
 		stats := summarizeKubeConnections(kubeConnections)
 		table = make([]Fielder, len(stats))
 		for i, _ := range stats {
-			table[i] = &stats[i]
+			table[i] = connectionCountRow{cc: &stats[i], columns: extraColumns}
 		}
 		columns = connectionStatFields
+		if config.groupBy == groupByWorkload {
+			columns = workloadStatFields()
+		}
+		for _, column := range extraColumns {
+			columns = append(columns, column.header)
+		}
 	} else {
+		var extraColumns []kubeConnectionColumn
+		if config.remoteNames {
+			extraColumns = append(extraColumns, remoteNameColumn)
+		}
+		if config.kubeletPods {
+			extraColumns = append(extraColumns, podInfoConnectionColumns()...)
+		}
+		if config.containerInfo {
+			extraColumns = append(extraColumns, containerInfoConnectionColumns(!config.kubeletPods)...)
+		}
+		if cluster != nil {
+			extraColumns = append(extraColumns, remoteEndpointConnectionColumns()...)
+		}
+		if config.peers {
+			extraColumns = append(extraColumns, peerConnectionColumns()...)
+		}
+		if config.tcpInfo {
+			extraColumns = append(extraColumns, tcpInfoColumns...)
+		}
+
 		table = make([]Fielder, len(kubeConnections))
 		for i, _ := range kubeConnections {
-			table[i] = &kubeConnections[i]
+			table[i] = kubeConnectionRow{kc: &kubeConnections[i], columns: extraColumns}
 		}
 		columns = kubeConnectionHeaders
+		for _, column := range extraColumns {
+			columns = append(columns, column.header)
+		}
+	}
+
+	return table, columns, nil
+}
+
+// This is effectively main, but moving it to a separate function
+// makes the error handling simpler
+func cnetstat() error {
+	config, err := parseArgs()
+	if err != nil {
+		return err
+	}
+
+	// It would be possible to run as non-root and return less
+	// information, but that makes the netstat parsing more
+	// complicated (since netstat will also print a warning
+	// message), and for our use-case we really want all the data,
+	// so just run it as root.
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("cnetstat must run as root")
+	}
+
+	resolver, err := buildPodResolver(config)
+	if err != nil {
+		return err
+	}
+
+	var cluster *ClusterIndex
+	if config.remotePods && !config.unixSockets {
+		cluster, err = startClusterWatch(config.apiServer)
+		if err != nil {
+			return err
+		}
+		defer cluster.close()
+	}
+
+	// Without --interval, we print one snapshot without a timestamp
+	for snapshot := 1; ; snapshot++ {
+		var timestamp string
+		if config.interval > 0 {
+			timestamp = time.Now().Format(snapshotTimeLayout)
+		}
+
+		err = printSnapshot(config, resolver, cluster, timestamp)
+		if err != nil {
+			return err
+		}
+
+		if config.interval == 0 || snapshot == config.count {
+			return nil
+		}
+
+		time.Sleep(config.interval)
+		resolver, err = refreshPodResolver(config, resolver)
+		if err != nil {
+			return err
+		}
+		if config.outputFormat == tableFormat {
+			fmt.Println()
+		}
+	}
+}
+
+// RFC 3339 with milliseconds, since --interval can be shorter than a
+// second. We always print three digits, so that timestamps line up.
+const snapshotTimeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+// Print the node's connections, or its Unix sockets, once. If timestamp
+// isn't "", print it above the table, or add it to each row of JSON.
+func printSnapshot(config CnetstatConfig, resolver *PodResolver, cluster *ClusterIndex, timestamp string) error {
+	namespaces, err := listNetNamespaces(config.netnsDirs)
+	if err != nil {
+		return err
+	}
+
+	var table []Fielder
+	var columns []string
+	if config.unixSockets {
+		table, columns, err = unixSocketTable(config, namespaces, resolver)
+	} else {
+		table, columns, err = connectionTable(config, namespaces, resolver, cluster)
+	}
+	if err != nil {
+		return err
 	}
 
 	switch config.outputFormat {
 	case jsonFormat:
+		if timestamp != "" {
+			table, columns = addTimestamps(table, columns, timestamp)
+		}
 		printJsonTable(table, columns, os.Stdout)
 	case tableFormat:
+		if timestamp != "" {
+			fmt.Println(timestamp)
+		}
 		prettyPrintTable(table, columns, os.Stdout)
 	}
 
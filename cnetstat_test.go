@@ -1,7 +1,10 @@
 package main
 
 import (
+	"fmt"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestSummarizeEmpty(t *testing.T) {
@@ -52,10 +55,21 @@ func TestSummarize(t *testing.T) {
 			connectionState: "ESTABLISHED",
 			pid:             85,
 		},
+		Connection{
+			protocol:        "udp",
+			localHost:       "127.0.0.1",
+			localPort:       "4823",
+			remoteHost:      "10.0.5.9",
+			remotePort:      "5086",
+			connectionState: "ESTABLISHED",
+			pid:             42,
+		},
 	}
 
 	// The first two connections are from the same container. The
-	// second two are from different containers.
+	// second two are from different containers. The last one is
+	// from the same container as the first two, to the same remote
+	// endpoint, but over UDP.
 	kubeConns := []KubeConnection{
 		KubeConnection{
 			conn: conns[0],
@@ -89,6 +103,14 @@ func TestSummarize(t *testing.T) {
 				ContainerName: "log-shipper",
 			},
 		},
+		KubeConnection{
+			conn: conns[4],
+			container: ContainerPath{
+				PodNamespace:  "myapp",
+				PodName:       "frontend",
+				ContainerName: "fe-server",
+			},
+		},
 	}
 
 	expectedStats := []ConnectionCount{
@@ -99,6 +121,7 @@ func TestSummarize(t *testing.T) {
 					PodName:       "frontend",
 					ContainerName: "fe-server",
 				},
+				protocol:   "tcp",
 				remoteHost: "10.0.5.9",
 				remotePort: "5086",
 			},
@@ -111,6 +134,20 @@ func TestSummarize(t *testing.T) {
 					PodName:       "frontend",
 					ContainerName: "fe-server",
 				},
+				protocol:   "udp",
+				remoteHost: "10.0.5.9",
+				remotePort: "5086",
+			},
+			count: 1,
+		},
+		ConnectionCount{
+			connId: KubeConnectionId{
+				container: ContainerPath{
+					PodNamespace:  "myapp",
+					PodName:       "frontend",
+					ContainerName: "fe-server",
+				},
+				protocol:   "tcp",
 				remoteHost: "10.0.3.4",
 				remotePort: "6230",
 			},
@@ -123,6 +160,7 @@ func TestSummarize(t *testing.T) {
 					PodName:       "frontend",
 					ContainerName: "log-shipper",
 				},
+				protocol:   "tcp",
 				remoteHost: "10.0.3.4",
 				remotePort: "6230",
 			},
@@ -140,7 +178,7 @@ func TestSummarize(t *testing.T) {
 	// Entries in stats that we expected to see
 	statWasExpected := make([]bool, len(stats))
 	// Entries in expectedOutput that we saw in stats
-	expectedWasSeen := make([]bool, 3)
+	expectedWasSeen := make([]bool, len(expectedStats))
 
 	for i, stat := range stats {
 		for j, expected := range expectedStats {
@@ -163,3 +201,134 @@ func TestSummarize(t *testing.T) {
 		}
 	}
 }
+
+func TestFilterConnectionStates(t *testing.T) {
+	conns := []Connection{
+		Connection{remotePort: "1", connectionState: "ESTABLISHED"},
+		Connection{remotePort: "2", connectionState: "TIME_WAIT"},
+		Connection{remotePort: "3", connectionState: "CLOSE_WAIT"},
+	}
+
+	all := filterConnectionStates(conns, nil)
+	if len(all) != 3 {
+		t.Errorf("Expected no filtering with no states, got %v", all)
+	}
+
+	filtered := filterConnectionStates(conns, []string{"TIME_WAIT", "CLOSE_WAIT"})
+	if len(filtered) != 2 || filtered[0] != conns[1] || filtered[1] != conns[2] {
+		t.Errorf("Got %v from filtering on TIME_WAIT,CLOSE_WAIT", filtered)
+	}
+}
+
+func TestSummarizeServers(t *testing.T) {
+	container := ContainerPath{
+		PodNamespace:  "myapp",
+		PodName:       "frontend",
+		ContainerName: "fe-server",
+	}
+	kubeConns := []KubeConnection{
+		KubeConnection{
+			conn: Connection{protocol: "tcp", localHost: "0.0.0.0", localPort: "8080",
+				remoteHost: "0.0.0.0", remotePort: "*", connectionState: "LISTEN"},
+			container: container,
+		},
+		KubeConnection{
+			conn: Connection{protocol: "tcp6", localHost: "::", localPort: "8443",
+				remoteHost: "::", remotePort: "*", connectionState: "LISTEN"},
+			container: container,
+		},
+	}
+
+	stats := summarizeKubeConnections(kubeConns)
+	if len(stats) != 2 {
+		t.Fatalf("Expected servers on different ports to be counted separately, got %v", stats)
+	}
+	for _, stat := range stats {
+		if stat.connId.listenPort != "8080" && stat.connId.listenPort != "8443" {
+			t.Errorf("Unexpected listen port in %v", stat)
+		}
+	}
+}
+
+func TestSummarizeQueues(t *testing.T) {
+	container := ContainerPath{
+		PodNamespace:  "myapp",
+		PodName:       "backend",
+		ContainerName: "be-server",
+	}
+	kubeConns := []KubeConnection{
+		KubeConnection{
+			conn: Connection{protocol: "tcp", localPort: "5069", remoteHost: "10.0.5.9",
+				remotePort: "5432", recvQ: 100, sendQ: 20},
+			container: container,
+		},
+		KubeConnection{
+			conn: Connection{protocol: "tcp", localPort: "5070", remoteHost: "10.0.5.9",
+				remotePort: "5432", recvQ: 0, sendQ: 4000},
+			container: container,
+		},
+	}
+
+	stats := summarizeKubeConnections(kubeConns)
+	if len(stats) != 1 {
+		t.Fatalf("Expected one stat, got %v", stats)
+	}
+	if stats[0].count != 2 || stats[0].totalQueued != 4120 || stats[0].maxQueued != 4000 {
+		t.Errorf("Got %v, expected 2 connections with 4120 bytes queued, at most 4000 in one", stats[0])
+	}
+}
+
+func TestSummarizeContainerInfo(t *testing.T) {
+	container := ContainerPath{
+		PodNamespace:  "myapp",
+		PodName:       "frontend",
+		ContainerName: "fe-server",
+	}
+	conn := Connection{protocol: "tcp", localHost: "10.240.0.4", localPort: "4592",
+		remoteHost: "10.2.9.76", remotePort: "443", connectionState: "ESTABLISHED"}
+	// Connections from a pod, and from the pod that replaced it
+	// with the same name
+	kubeConns := []KubeConnection{
+		KubeConnection{conn: conn, container: container,
+			info: ContainerInfo{podUid: "3b8c2a9e", containerId: "56443455", image: "frontend:1.2", attempt: 1}},
+		KubeConnection{conn: conn, container: container,
+			info: ContainerInfo{podUid: "7f01d4c3", containerId: "a01098fd", image: "frontend:1.3"}},
+	}
+
+	stats := summarizeKubeConnections(kubeConns)
+	if len(stats) != 2 {
+		t.Fatalf("Expected recreated pods to be counted separately, got %v", stats)
+	}
+
+	columns := containerInfoCountColumns(true)
+	for _, stat := range stats {
+		fields := connectionCountRow{cc: &stat, columns: columns}.Fields()
+		info := strings.Join(fields[len(fields)-len(columns):], " ")
+		if info != "3b8c2a9e 56443455 frontend:1.2 1" && info != "7f01d4c3 a01098fd frontend:1.3 0" {
+			t.Errorf("Unexpected container info fields %v", info)
+		}
+	}
+
+	expectEqual(t, len(containerInfoConnectionColumns(false)), 3, "Expected to leave out the Pod UID column")
+	fields := kubeConnectionRow{kc: &kubeConns[1], columns: containerInfoConnectionColumns(false)}.Fields()
+	expectEqual(t, strings.Join(fields[len(fields)-3:], " "), "a01098fd frontend:1.3 0", "Unexpected container info fields")
+}
+
+func TestNamespaceError(t *testing.T) {
+	namespace := NamespaceData{Ns: 4026532301}
+	err := fmt.Errorf("Couldn't open net namespace")
+
+	expectEqual(t, namespaceError(CnetstatConfig{}, namespace, err), err,
+		"Expected a namespace error to stop a single snapshot")
+	expectEqual(t, namespaceError(CnetstatConfig{interval: 5 * time.Second}, namespace, err), nil,
+		"Expected to skip a namespace error between periodic snapshots")
+}
+
+func TestSnapshotTimeLayout(t *testing.T) {
+	first := time.Date(2026, 10, 17, 12, 0, 5, 0, time.UTC)
+	second := first.Add(200 * time.Millisecond)
+
+	expectEqual(t, first.Format(snapshotTimeLayout), "2026-10-17T12:00:05.000Z", "Unexpected timestamp")
+	expectEqual(t, second.Format(snapshotTimeLayout), "2026-10-17T12:00:05.200Z",
+		"Expected snapshots less than a second apart to have different timestamps")
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// The CRI RuntimeService methods we call, as gRPC paths. See
+// https://github.com/kubernetes/cri-api/blob/master/pkg/apis/runtime/v1/api.proto
+const (
+	criListContainers  = "/runtime.v1.RuntimeService/ListContainers"
+	criContainerStatus = "/runtime.v1.RuntimeService/ContainerStatus"
+)
+
+// ContainerState.CONTAINER_RUNNING in the CRI API
+const criContainerRunning = 1
+
+// Where containerd and CRI-O listen for CRI requests by default
+const (
+	defaultContainerdSocket = "/run/containerd/containerd.sock"
+	defaultCrioSocket       = "/var/run/crio/crio.sock"
+)
+
+// A CRIClient calls a container runtime's CRI API over its Unix
+// socket. gRPC is HTTP/2 without TLS, with protobuf messages in the
+// request and response bodies.
+type CRIClient struct {
+	client *http.Client
+}
+
+func newCRIClient(socket string) *CRIClient {
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+
+	transport := unixSocketTransport(socket)
+	transport.Protocols = protocols
+
+	return &CRIClient{
+		client: &http.Client{Transport: transport, Timeout: subprocessTimeout},
+	}
+}
+
+// Make a unary gRPC call, and return the encoded response message
+func (c *CRIClient) call(method string, request []byte) ([]byte, error) {
+	// Each gRPC message has a 5-byte prefix: a compression flag
+	// and a big-endian length
+	body := make([]byte, 5, 5+len(request))
+	binary.BigEndian.PutUint32(body[1:5], uint32(len(request)))
+	body = append(body, request...)
+
+	req, err := http.NewRequest("POST", "http://localhost"+method, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%v failed with HTTP status %v", method, resp.Status)
+	}
+
+	// gRPC puts its status in the trailers, or in the headers if
+	// there is no response message
+	status := resp.Trailer.Get("Grpc-Status")
+	message := resp.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status = resp.Header.Get("Grpc-Status")
+		message = resp.Header.Get("Grpc-Message")
+	}
+	if status != "0" {
+		return nil, fmt.Errorf("%v failed with gRPC status %v: %v", method, status, message)
+	}
+
+	if len(data) < 5 {
+		return nil, fmt.Errorf("Short gRPC response from %v", method)
+	}
+	if data[0] != 0 {
+		return nil, fmt.Errorf("Compressed gRPC response from %v", method)
+	}
+	length := binary.BigEndian.Uint32(data[1:5])
+	if uint64(len(data)-5) < uint64(length) {
+		return nil, fmt.Errorf("Truncated gRPC response from %v", method)
+	}
+
+	return data[5 : 5+length], nil
+}
+
+// Parse a CRI Container message. We use
+//
+//	string id = 1;
+//	ContainerMetadata metadata = 3;
+//	ImageSpec image = 4;
+//	map<string, string> labels = 8;
+//
+// and ContainerMetadata and ImageSpec have the container's name and
+// image in field 1. ContainerMetadata has the attempt in field 2.
+func parseCriContainer(b []byte) (RuntimeContainer, error) {
+	fields, err := protoParse(b)
+	if err != nil {
+		return RuntimeContainer{}, err
+	}
+
+	var container RuntimeContainer
+	var metadataName string
+	labels := make(map[string]string)
+	for _, field := range fields {
+		switch field.number {
+		case 1:
+			container.id = string(field.bytes)
+		case 3:
+			metadata, err := protoParse(field.bytes)
+			if err != nil {
+				return RuntimeContainer{}, err
+			}
+			for _, metadataField := range metadata {
+				switch metadataField.number {
+				case 1:
+					metadataName = string(metadataField.bytes)
+				case 2:
+					container.attempt = int(metadataField.varint)
+				}
+			}
+		case 4:
+			image, err := protoParse(field.bytes)
+			if err != nil {
+				return RuntimeContainer{}, err
+			}
+			for _, imageField := range image {
+				if imageField.number == 1 {
+					container.image = string(imageField.bytes)
+				}
+			}
+		case 8:
+			key, value, err := protoParseMapEntry(field.bytes)
+			if err != nil {
+				return RuntimeContainer{}, err
+			}
+			labels[key] = value
+		}
+	}
+
+	// Everything that talks CRI is there to run Kubernetes pods
+	container.kubePath = kubePathFromLabels(labels)
+	container.kubePath.Kind = kubernetesKind
+	container.podUid = labels[podUidLabel]
+	if container.kubePath.ContainerName == "" {
+		container.kubePath.ContainerName = metadataName
+	}
+
+	return container, nil
+}
+
+// Parse a ListContainersResponse, which is
+//
+//	repeated Container containers = 1;
+func parseCriListContainersResponse(b []byte) ([]RuntimeContainer, error) {
+	fields, err := protoParse(b)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []RuntimeContainer
+	for _, field := range fields {
+		if field.number != 1 {
+			continue
+		}
+
+		container, err := parseCriContainer(field.bytes)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, container)
+	}
+
+	return result, nil
+}
+
+// Parse a verbose ContainerStatusResponse, and return the container's
+// root PID. The response is
+//
+//	ContainerStatus status = 1;
+//	map<string, string> info = 2;
+//
+// and info["info"] is a JSON object with a "pid" property.
+func parseCriContainerStatusResponse(b []byte) (int, error) {
+	fields, err := protoParse(b)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, field := range fields {
+		if field.number != 2 {
+			continue
+		}
+
+		key, value, err := protoParseMapEntry(field.bytes)
+		if err != nil {
+			return 0, err
+		}
+		if key != "info" {
+			continue
+		}
+
+		var info struct {
+			Pid int `json:"pid"`
+		}
+		err = json.Unmarshal([]byte(value), &info)
+		if err != nil {
+			return 0, err
+		}
+		if info.Pid == 0 {
+			return 0, fmt.Errorf("No PID in container status info")
+		}
+
+		return info.Pid, nil
+	}
+
+	return 0, fmt.Errorf("No verbose info in container status")
+}
+
+// List running containers, without their PIDs
+func (c *CRIClient) listContainers() ([]RuntimeContainer, error) {
+	// ListContainersRequest { ContainerFilter filter = 1; }
+	// ContainerFilter { ContainerStateValue state = 2; }
+	// ContainerStateValue { ContainerState state = 1; }
+	stateValue := protoAppendUint(nil, 1, criContainerRunning)
+	filter := protoAppendBytes(nil, 2, stateValue)
+	request := protoAppendBytes(nil, 1, filter)
+
+	response, err := c.call(criListContainers, request)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseCriListContainersResponse(response)
+}
+
+// Get the root PID of a container
+func (c *CRIClient) containerPid(id string) (int, error) {
+	// ContainerStatusRequest { string container_id = 1; bool verbose = 2; }
+	request := protoAppendString(nil, 1, id)
+	request = protoAppendBool(request, 2, true)
+
+	response, err := c.call(criContainerStatus, request)
+	if err != nil {
+		return 0, err
+	}
+
+	return parseCriContainerStatusResponse(response)
+}
+
+// List the running containers of the CRI runtime listening on socket,
+// with their PIDs
+func listCriContainers(socket string) ([]RuntimeContainer, error) {
+	client := newCRIClient(socket)
+
+	containers, err := client.listContainers()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []RuntimeContainer
+	for _, container := range containers {
+		pid, err := client.containerPid(container.id)
+		if err != nil {
+			// We expect errors here if a container was
+			// deleted after we listed it.
+			continue
+		}
+
+		container.pid = pid
+		result = append(result, container)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+// A container the fake CRI server knows about
+type fakeCriContainer struct {
+	id      string
+	name    string
+	attempt int
+	labels  map[string]string
+	pid     int
+	running bool
+}
+
+// A fake CRI runtime, serving ListContainers and ContainerStatus
+type fakeCriServer struct {
+	containers []fakeCriContainer
+}
+
+func (s *fakeCriServer) listContainers(request []byte) ([]byte, error) {
+	// Only return running containers if the request asks for them
+	onlyRunning := false
+	fields, err := protoParse(request)
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range fields {
+		if field.number != 1 {
+			continue
+		}
+		filter, err := protoParse(field.bytes)
+		if err != nil {
+			return nil, err
+		}
+		for _, filterField := range filter {
+			if filterField.number != 2 {
+				continue
+			}
+			state, err := protoParse(filterField.bytes)
+			if err != nil {
+				return nil, err
+			}
+			onlyRunning = len(state) == 1 && state[0].varint == criContainerRunning
+		}
+	}
+
+	var response []byte
+	for _, container := range s.containers {
+		if onlyRunning && !container.running {
+			continue
+		}
+
+		c := protoAppendString(nil, 1, container.id)
+		c = protoAppendString(c, 2, "sandbox-"+container.id)
+		c = protoAppendBytes(c, 3, protoAppendUint(protoAppendString(nil, 1, container.name), 2, uint64(container.attempt)))
+		c = protoAppendBytes(c, 4, protoAppendString(nil, 1, "registry.example/image:1"))
+		for key, value := range container.labels {
+			c = protoAppendMapEntry(c, 8, key, value)
+		}
+		response = protoAppendBytes(response, 1, c)
+	}
+
+	return response, nil
+}
+
+func (s *fakeCriServer) containerStatus(request []byte) ([]byte, error) {
+	fields, err := protoParse(request)
+	if err != nil {
+		return nil, err
+	}
+
+	var id string
+	var verbose bool
+	for _, field := range fields {
+		switch field.number {
+		case 1:
+			id = string(field.bytes)
+		case 2:
+			verbose = field.varint != 0
+		}
+	}
+
+	for _, container := range s.containers {
+		if container.id != id {
+			continue
+		}
+
+		response := protoAppendBytes(nil, 1, protoAppendString(nil, 1, id))
+		if verbose {
+			info := fmt.Sprintf(`{"sandboxID":"sandbox-%v","pid":%v,"removing":false}`, id, container.pid)
+			response = protoAppendMapEntry(response, 2, "info", info)
+		}
+		return response, nil
+	}
+
+	return nil, fmt.Errorf("container %v not found", id)
+}
+
+func (s *fakeCriServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil || len(body) < 5 {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	var response []byte
+	switch r.URL.Path {
+	case criListContainers:
+		response, err = s.listContainers(body[5:])
+	case criContainerStatus:
+		response, err = s.containerStatus(body[5:])
+	default:
+		err = fmt.Errorf("unknown method %v", r.URL.Path)
+	}
+
+	w.Header().Set("Content-Type", "application/grpc")
+	if err != nil {
+		// A trailers-only response. 5 is NOT_FOUND.
+		w.Header().Set("Grpc-Status", "5")
+		w.Header().Set("Grpc-Message", err.Error())
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	w.Header().Set("Trailer", "Grpc-Status")
+	w.WriteHeader(http.StatusOK)
+	prefix := make([]byte, 5)
+	binary.BigEndian.PutUint32(prefix[1:], uint32(len(response)))
+	w.Write(prefix)
+	w.Write(response)
+	w.Header().Set("Grpc-Status", "0")
+}
+
+// Serve s over h2c on a Unix socket, and return the socket's path
+func startFakeCriServer(t *testing.T, s *fakeCriServer) string {
+	socket := filepath.Join(t.TempDir(), "cri.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Couldn't listen on %v: %v", socket, err)
+	}
+
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	server := &http.Server{Handler: s, Protocols: protocols}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	return socket
+}
+
+var fakeCriContainers = []fakeCriContainer{
+	{id: "56443455", name: "fe-server", attempt: 3, pid: 36, running: true,
+		labels: map[string]string{
+			"io.kubernetes.pod.name":       "frontend",
+			"io.kubernetes.pod.namespace":  "my-app",
+			"io.kubernetes.container.name": "fe-server",
+			"component":                    "foo,bar",
+		}},
+	{id: "65323bda", name: "be-server", pid: 9486, running: true,
+		labels: map[string]string{
+			"io.kubernetes.pod.name":       "backend",
+			"io.kubernetes.pod.namespace":  "my-app",
+			"io.kubernetes.container.name": "be-server",
+		}},
+	{id: "a01098fd", name: "old-server", pid: 0, running: false,
+		labels: map[string]string{
+			"io.kubernetes.pod.name":       "backend",
+			"io.kubernetes.pod.namespace":  "my-app",
+			"io.kubernetes.container.name": "old-server",
+		}},
+	{id: "fab8905c", name: "sidecar", pid: 5000, running: true,
+		labels: map[string]string{}},
+}
+
+func TestListCriContainers(t *testing.T) {
+	socket := startFakeCriServer(t, &fakeCriServer{containers: fakeCriContainers})
+
+	containers, err := listCriContainers(socket)
+	if err != nil {
+		t.Fatalf("Couldn't list containers: %v", err)
+	}
+
+	expected := []RuntimeContainer{
+		{id: "56443455", pid: 36, attempt: 3, image: "registry.example/image:1", kubePath: ContainerPath{"my-app", "frontend", "fe-server", kubernetesKind}},
+		{id: "65323bda", pid: 9486, image: "registry.example/image:1", kubePath: ContainerPath{"my-app", "backend", "be-server", kubernetesKind}},
+		{id: "fab8905c", pid: 5000, image: "registry.example/image:1", kubePath: ContainerPath{"", "", "sidecar", kubernetesKind}},
+	}
+	if len(containers) != len(expected) {
+		t.Fatalf("Got %v containers, expected %v", len(containers), len(expected))
+	}
+	for i := range expected {
+		if containers[i] != expected[i] {
+			t.Errorf("Got container %+v, expected %+v", containers[i], expected[i])
+		}
+	}
+}
+
+func TestCriContainerPidErrors(t *testing.T) {
+	socket := startFakeCriServer(t, &fakeCriServer{containers: fakeCriContainers})
+	client := newCRIClient(socket)
+
+	_, err := client.containerPid("missing")
+	if err == nil {
+		t.Errorf("Expected an error for a missing container")
+	}
+
+	_, err = newCRIClient(filepath.Join(t.TempDir(), "nothing.sock")).listContainers()
+	if err == nil {
+		t.Errorf("Expected an error with no CRI server")
+	}
+}
+
+func TestParseCriContainerStatusResponse(t *testing.T) {
+	response := protoAppendMapEntry(nil, 2, "info", `{"pid":1234}`)
+	pid, err := parseCriContainerStatusResponse(response)
+	expectEqual(t, err, nil, "Unexpected error parsing container status")
+	expectEqual(t, pid, 1234, "Unexpected PID from container status")
+
+	// Not verbose
+	response = protoAppendBytes(nil, 1, protoAppendString(nil, 1, "56443455"))
+	_, err = parseCriContainerStatusResponse(response)
+	if err == nil {
+		t.Errorf("Expected an error from a status without info")
+	}
+}
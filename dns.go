@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long we remember reverse DNS answers, and failures to get them
+const (
+	dnsCacheTTL         = 5 * time.Minute
+	dnsNegativeCacheTTL = 30 * time.Second
+)
+
+// How many reverse DNS lookups we run at once
+const dnsLookupWorkers = 16
+
+type dnsCacheEntry struct {
+	name    string // "" if the lookup failed
+	expires time.Time
+}
+
+// A ReverseDNSCache does reverse DNS lookups with a timeout, and
+// remembers the answers, including failures, so that we don't wait on
+// the same slow lookup twice.
+type ReverseDNSCache struct {
+	lookupAddr func(ctx context.Context, addr string) ([]string, error)
+	timeout    time.Duration
+	now        func() time.Time
+
+	lock    sync.Mutex
+	entries map[string]dnsCacheEntry
+}
+
+func newReverseDNSCache(lookupAddr func(ctx context.Context, addr string) ([]string, error), timeout time.Duration) *ReverseDNSCache {
+	return &ReverseDNSCache{
+		lookupAddr: lookupAddr,
+		timeout:    timeout,
+		now:        time.Now,
+		entries:    make(map[string]dnsCacheEntry),
+	}
+}
+
+// The cache every lookup in this process shares. cnetstat sets its
+// timeout from the command line before using it.
+var reverseDNS = newReverseDNSCache(net.DefaultResolver.LookupAddr, time.Second)
+
+// Return the host name of addr, or "" if it doesn't have one or the
+// lookup times out
+func (cache *ReverseDNSCache) lookup(addr string) string {
+	cache.lock.Lock()
+	entry, ok := cache.entries[addr]
+	cache.lock.Unlock()
+	if ok && cache.now().Before(entry.expires) {
+		return entry.name
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cache.timeout)
+	defer cancel()
+
+	names, err := cache.lookupAddr(ctx, addr)
+	if err == nil && len(names) > 0 {
+		entry = dnsCacheEntry{
+			name:    strings.TrimSuffix(names[0], "."),
+			expires: cache.now().Add(dnsCacheTTL),
+		}
+	} else {
+		entry = dnsCacheEntry{
+			expires: cache.now().Add(dnsNegativeCacheTTL),
+		}
+	}
+
+	cache.lock.Lock()
+	cache.entries[addr] = entry
+	cache.lock.Unlock()
+
+	return entry.name
+}
+
+// Look up the host names of many addresses at once, and return a map
+// from addresses to names. Addresses without names, and strings that
+// aren't IP addresses at all, are left out of the map.
+func (cache *ReverseDNSCache) lookupAll(addrs []string) map[string]string {
+	// Only look each address up once
+	unique := make(map[string]bool)
+	for _, addr := range addrs {
+		ip := net.ParseIP(addr)
+		if ip != nil && !ip.IsUnspecified() {
+			unique[addr] = true
+		}
+	}
+
+	work := make(chan string)
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	result := make(map[string]string)
+
+	for i := 0; i < dnsLookupWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for addr := range work {
+				name := cache.lookup(addr)
+				if name != "" {
+					lock.Lock()
+					result[addr] = name
+					lock.Unlock()
+				}
+			}
+		}()
+	}
+
+	for addr := range unique {
+		work <- addr
+	}
+	close(work)
+	wg.Wait()
+
+	return result
+}
+
+// Set the remoteName of every connection whose remote host has one
+func resolveRemoteNames(connections []KubeConnection, cache *ReverseDNSCache) {
+	addrs := make([]string, len(connections))
+	for i, kc := range connections {
+		addrs[i] = kc.conn.remoteHost
+	}
+
+	names := cache.lookupAll(addrs)
+	for i := range connections {
+		connections[i].remoteName = names[connections[i].conn.remoteHost]
+	}
+}
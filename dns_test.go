@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A fake resolver that counts how many times it looks up each address
+type fakeResolver struct {
+	lock    sync.Mutex
+	names   map[string]string
+	lookups map[string]int
+}
+
+func newFakeResolver(names map[string]string) *fakeResolver {
+	return &fakeResolver{names: names, lookups: make(map[string]int)}
+}
+
+func (r *fakeResolver) lookupAddr(ctx context.Context, addr string) ([]string, error) {
+	r.lock.Lock()
+	r.lookups[addr] += 1
+	r.lock.Unlock()
+
+	if addr == "10.0.9.9" {
+		// A DNS server that never answers
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	name, ok := r.names[addr]
+	if !ok {
+		return nil, fmt.Errorf("no name for %v", addr)
+	}
+	return []string{name}, nil
+}
+
+func TestReverseDNSCache(t *testing.T) {
+	resolver := newFakeResolver(map[string]string{"10.0.3.4": "backend.myapp.svc.cluster.local."})
+	cache := newReverseDNSCache(resolver.lookupAddr, 10*time.Millisecond)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		expectEqual(t, cache.lookup("10.0.3.4"), "backend.myapp.svc.cluster.local",
+			"Unexpected name for 10.0.3.4")
+		expectEqual(t, cache.lookup("10.0.5.9"), "", "Expected no name for 10.0.5.9")
+		expectEqual(t, cache.lookup("10.0.9.9"), "", "Expected a timeout for 10.0.9.9")
+	}
+
+	// The second round should have come from the cache, including
+	// the failures
+	for _, addr := range []string{"10.0.3.4", "10.0.5.9", "10.0.9.9"} {
+		if resolver.lookups[addr] != 1 {
+			t.Errorf("Looked up %v %v times, expected once", addr, resolver.lookups[addr])
+		}
+	}
+
+	// Failures expire before successes do
+	now = now.Add(dnsNegativeCacheTTL + time.Second)
+	cache.lookup("10.0.3.4")
+	cache.lookup("10.0.5.9")
+	if resolver.lookups["10.0.3.4"] != 1 || resolver.lookups["10.0.5.9"] != 2 {
+		t.Errorf("Expected only the failed lookup to be retried, got %v", resolver.lookups)
+	}
+}
+
+func TestResolveRemoteNames(t *testing.T) {
+	resolver := newFakeResolver(map[string]string{"10.0.3.4": "backend.example.com."})
+	cache := newReverseDNSCache(resolver.lookupAddr, time.Second)
+
+	connections := []KubeConnection{
+		KubeConnection{conn: Connection{remoteHost: "10.0.3.4", remotePort: "443"}},
+		KubeConnection{conn: Connection{remoteHost: "10.0.3.4", remotePort: "80"}},
+		KubeConnection{conn: Connection{remoteHost: "10.0.5.9", remotePort: "443"}},
+		KubeConnection{conn: Connection{remoteHost: "0.0.0.0", remotePort: "*"}},
+	}
+	resolveRemoteNames(connections, cache)
+
+	expected := []string{"backend.example.com", "backend.example.com", "", ""}
+	for i, name := range expected {
+		if connections[i].remoteName != name {
+			t.Errorf("Got remote name %#v for %v, expected %#v", connections[i].remoteName,
+				connections[i].conn.remoteHost, name)
+		}
+		if connections[i].conn.remoteHost == "" {
+			t.Errorf("Remote host of %v was overwritten", connections[i])
+		}
+	}
+
+	// Each address is looked up once, and unspecified addresses
+	// not at all
+	if resolver.lookups["10.0.3.4"] != 1 || resolver.lookups["0.0.0.0"] != 0 {
+		t.Errorf("Unexpected lookups %v", resolver.lookups)
+	}
+}
@@ -1,19 +1,49 @@
 package main
 
 import (
-	"bufio"
-	"context"
+	"encoding/json"
 	"fmt"
 	"io"
-	"os/exec"
+	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 )
 
-// A ContainerPath identifies a container in Kubernetes
+// Where the Docker Engine API listens by default
+const defaultDockerSocket = "/var/run/docker.sock"
+
+// How many containers we inspect at once
+const dockerInspectWorkers = 16
+
+// A ContainerPath identifies a container in Kubernetes. Containers
+// outside Kubernetes use the same fields for what identifies them
+// instead, as plainContainerPath describes.
 type ContainerPath struct {
 	PodNamespace  string
 	PodName       string
 	ContainerName string
+	Kind          string // kubernetesKind, composeKind and so on
+}
+
+// What the runtime tells us about a container beyond its ContainerPath.
+// The pod UID and container ID tell apart pods and containers that were
+// deleted and recreated with the same names, and the image says which
+// build a container runs.
+type ContainerInfo struct {
+	podUid      string
+	containerId string
+	image       string // The image reference the container was created from
+	attempt     int    // How many times kubelet restarted the container
+}
+
+// Format the container's attempt, or return "" if it isn't a container
+// that kubelet restarts
+func (info ContainerInfo) restarts() string {
+	if info.podUid == "" || info.containerId == "" {
+		return ""
+	}
+	return strconv.Itoa(info.attempt)
 }
 
 // A DockerContainer connects a container's docker ID and its
@@ -21,91 +51,186 @@ type ContainerPath struct {
 type DockerContainer struct {
 	kubePath ContainerPath
 	dockerId string
+	podUid   string
+	attempt  int // How many times kubelet restarted the container
+	image    string
 }
 
-// parseDockerContainerList parses the output of
-//    `docker ps --format "{{.ID}} {{.Labels}}"`
+// Parse the name dockershim gives a Kubernetes container, which is
+//    k8s_<container>_<pod>_<namespace>_<pod uid>_<attempt>
+// Kubernetes names can't contain underscores. ok is false if name
+// isn't in this format.
+func parseDockershimName(name string) (kubePath ContainerPath, podUid string, attempt int, ok bool) {
+	parts := strings.Split(strings.TrimPrefix(name, "/"), "_")
+	if len(parts) != 6 || parts[0] != "k8s" {
+		return ContainerPath{}, "", 0, false
+	}
+
+	attempt, err := strconv.Atoi(parts[5])
+	if err != nil {
+		return ContainerPath{}, "", 0, false
+	}
+
+	kubePath = ContainerPath{
+		PodNamespace:  parts[3],
+		PodName:       parts[2],
+		ContainerName: parts[1],
+		Kind:          kubernetesKind,
+	}
+	return kubePath, parts[4], attempt, true
+}
+
+// parseDockerContainerList parses the JSON output of the Docker
+// Engine API's
+//    GET /containers/json
 // and returns a list of DockerContainers
 //
 // There will be one Docker container per pod with the special
 // container_name 'POD'. This container holds the cgroups for the pod,
 // but doesn't correspond to any Kubernetes container.
+//
+// We find containers' ContainerPaths in their labels. If a container
+// has lost its labels, we fall back to parsing its dockershim name.
+// Containers that Kubernetes didn't start get a plainContainerPath.
 func parseDockerContainerList(docker_out io.Reader) ([]DockerContainer, error) {
+	var containers []struct {
+		Id     string
+		Names  []string
+		Image  string
+		Labels map[string]string
+	}
+	err := json.NewDecoder(docker_out).Decode(&containers)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't parse Docker container list: %v", err)
+	}
+
 	var result []DockerContainer
+	for _, container := range containers {
+		dockerContainer := DockerContainer{kubePath: kubePathFromLabels(container.Labels),
+			dockerId: container.Id, podUid: container.Labels[podUidLabel], image: container.Image}
+		dockerContainer.attempt, _ = strconv.Atoi(container.Labels[restartCountLabel])
+
+		for _, name := range container.Names {
+			kubePath, podUid, attempt, ok := parseDockershimName(name)
+			if !ok {
+				continue
+			}
 
-	var scanner = bufio.NewScanner(docker_out)
-	for scanner.Scan() {
-		line := scanner.Text()
-		columns := strings.SplitN(line, " ", 2)
-		if len(columns) != 2 {
-			return nil, fmt.Errorf("Couldn't parse Docker output line %v", line)
+			if dockerContainer.kubePath == (ContainerPath{}) {
+				dockerContainer.kubePath = kubePath
+				dockerContainer.attempt = attempt
+			}
+			if dockerContainer.podUid == "" {
+				dockerContainer.podUid = podUid
+			}
+			break
 		}
 
-		container_id := columns[0]
-		labels := strings.Split(columns[1], ",")
-		container_path := ContainerPath{}
+		if dockerContainer.kubePath == (ContainerPath{}) && len(container.Names) > 0 {
+			// Docker names start with a slash
+			name := strings.TrimPrefix(container.Names[0], "/")
+			dockerContainer.kubePath = plainContainerPath(name, container.Labels)
+		}
 
-		for _, label := range labels {
-			if strings.Contains(label, "=") {
-				parts := strings.SplitN(label, "=", 2)
-				if len(parts) != 2 {
-					return nil, fmt.Errorf("Couldn't parse container label %v", label)
-				}
+		result = append(result, dockerContainer)
+	}
 
-				key := parts[0]
-				value := parts[1]
+	return result, nil
+}
 
-				switch key {
-				case "io.kubernetes.pod.name":
-					container_path.PodName = value
-				case "io.kubernetes.pod.namespace":
-					container_path.PodNamespace = value
-				case "io.kubernetes.container.name":
-					container_path.ContainerName = value
-				}
-			}
+// Parse the JSON output of the Docker Engine API's
+//    GET /containers/{id}/json
+// and return the container's root PID
+func parseDockerInspect(docker_out io.Reader) (int, error) {
+	var container struct {
+		State struct {
+			Pid int
 		}
-
-		result = append(result, DockerContainer{kubePath: container_path,
-			dockerId: container_id})
+	}
+	err := json.NewDecoder(docker_out).Decode(&container)
+	if err != nil {
+		return 0, fmt.Errorf("Couldn't parse Docker container: %v", err)
 	}
 
-	return result, nil
+	return container.State.Pid, nil
 }
 
-// Build a map from host PIDs to ContainerPaths.
-func buildPidMap() (map[int]ContainerPath, error) {
-	ctx, _ := context.WithTimeout(context.Background(), subprocessTimeout)
-	dockerPsOut, err := exec.CommandContext(ctx, "docker", "ps", "--format", "{{.ID}} {{.Labels}}").Output()
+// Make a GET request to the Docker Engine API, and return the response
+// body if it succeeded. The caller must close it.
+func dockerGet(client *http.Client, path string) (io.ReadCloser, error) {
+	resp, err := client.Get("http://docker" + path)
 	if err != nil {
 		return nil, err
 	}
 
-	dockerContainers, err := parseDockerContainerList(strings.NewReader(string(dockerPsOut)))
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("Docker request %v failed with HTTP status %v", path, resp.Status)
+	}
+
+	return resp.Body, nil
+}
+
+// Get the root PID of a container from the Docker Engine API
+func dockerContainerPid(client *http.Client, id string) (int, error) {
+	body, err := dockerGet(client, "/containers/"+id+"/json")
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
+	defer body.Close()
+
+	return parseDockerInspect(body)
+}
 
-	pidMap := make(map[int]ContainerPath)
+// List running Docker containers, with their root PIDs, by talking to
+// the Docker Engine API on socket. We list the containers in one
+// request and then inspect them in parallel, over one connection pool.
+func listDockerContainers(socket string) ([]RuntimeContainer, error) {
+	transport := unixSocketTransport(socket)
+	transport.MaxIdleConnsPerHost = dockerInspectWorkers
+	client := &http.Client{Transport: transport, Timeout: subprocessTimeout}
 
-	for _, container := range dockerContainers {
-		ctx, _ := context.WithTimeout(context.Background(), subprocessTimeout)
-		dockerInspectOut, err := exec.CommandContext(
-			ctx, "docker", "inspect", "--format", "{{.State.Pid}}", container.dockerId).Output()
-		if err != nil {
-			// We expect errors here if a container was
-			// deleted between `docker ps` and here.
-			continue
-		}
+	body, err := dockerGet(client, "/containers/json")
+	if err != nil {
+		return nil, err
+	}
+	dockerContainers, err := parseDockerContainerList(body)
+	body.Close()
+	if err != nil {
+		return nil, err
+	}
 
-		var rootPid int
-		_, err = fmt.Fscan(strings.NewReader(string(dockerInspectOut)), &rootPid)
-		if err != nil {
-			return nil, err
-		}
+	// Each worker fills in the PIDs of the containers it inspects.
+	// Containers we couldn't inspect keep PID 0.
+	result := make([]RuntimeContainer, len(dockerContainers))
+	work := make(chan int)
+	var wg sync.WaitGroup
+
+	for i := 0; i < dockerInspectWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				container := dockerContainers[i]
+				result[i] = RuntimeContainer{id: container.dockerId, podUid: container.podUid,
+					attempt: container.attempt, image: container.image, kubePath: container.kubePath}
+
+				rootPid, err := dockerContainerPid(client, container.dockerId)
+				if err != nil {
+					// We expect errors here if a container
+					// was deleted after we listed it.
+					continue
+				}
+				result[i].pid = rootPid
+			}
+		}()
+	}
 
-		pidMap[rootPid] = container.kubePath
+	for i := range dockerContainers {
+		work <- i
 	}
+	close(work)
+	wg.Wait()
 
-	return pidMap, nil
+	return result, nil
 }
@@ -1,37 +1,49 @@
 package main
 
 import (
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
 	"strings"
 	"testing"
 )
 
-// This should match the output format of 'docker ps --format "{{.ID}} {{.Labels}}"'
-const dockerPsOutput = `56443455 component=foo,io.kubernetes.pod.name=frontend,io.kubernetes.pod.namespace=my-app,a=b,c=d,io.kubernetes.container.name=fe-server
-fab8905c component=bar,io.kubernetes.pod.name=frontend,io.kubernetes.pod.namespace=my-app,x=y,z=w,io.kubernetes.container.name=log-shipper
-a01098fd component=baz,io.kubernetes.pod.name=frontend,io.kubernetes.pod.namespace=my-app,g=x,h=j,io.kubernetes.container.name=POD
-65323bda component=bot,io.kubernetes.pod.name=backend,io.kubernetes.pod.namespace=my-app,h=k,j=r,io.kubernetes.container.name=be-server`
+// This should match the output format of the Docker Engine API's
+// 'GET /containers/json'. Docker prints more fields than this.
+const dockerPsOutput = `[
+  {"Id": "56443455", "Names": ["/k8s_fe-server_frontend_my-app_0"], "Labels": {"component": "foo", "io.kubernetes.pod.uid": "3b8c2a9e-1f4d-4b8e-9a52-6a1e0f3c7d21", "io.kubernetes.pod.name": "frontend", "io.kubernetes.pod.namespace": "my-app", "a": "b,c=d", "io.kubernetes.container.name": "fe-server"}},
+  {"Id": "fab8905c", "Names": ["/k8s_log-shipper_frontend_my-app_0"], "Labels": {"component": "bar", "io.kubernetes.pod.name": "frontend", "io.kubernetes.pod.namespace": "my-app", "x": "y", "io.kubernetes.container.name": "log-shipper"}},
+  {"Id": "a01098fd", "Names": ["/k8s_POD_frontend_my-app_0"], "Labels": {"component": "baz", "io.kubernetes.pod.name": "frontend", "io.kubernetes.pod.namespace": "my-app", "io.kubernetes.container.name": "POD"}},
+  {"Id": "65323bda", "Names": ["/k8s_be-server_backend_my-app_0"], "Labels": {"component": "bot,io.kubernetes.pod.name=wrong", "io.kubernetes.pod.name": "backend", "io.kubernetes.pod.namespace": "my-app", "io.kubernetes.container.name": "be-server"}}
+]`
 
 var parsedOutput = [4]DockerContainer{
 	DockerContainer{dockerId: "56443455",
+		podUid: "3b8c2a9e-1f4d-4b8e-9a52-6a1e0f3c7d21",
 		kubePath: ContainerPath{
 			PodName:       "frontend",
 			PodNamespace:  "my-app",
-			ContainerName: "fe-server"}},
+			ContainerName: "fe-server",
+			Kind:          kubernetesKind}},
 	DockerContainer{dockerId: "fab8905c",
 		kubePath: ContainerPath{
 			PodName:       "frontend",
 			PodNamespace:  "my-app",
-			ContainerName: "log-shipper"}},
+			ContainerName: "log-shipper",
+			Kind:          kubernetesKind}},
 	DockerContainer{dockerId: "a01098fd",
 		kubePath: ContainerPath{
 			PodName:       "frontend",
 			PodNamespace:  "my-app",
-			ContainerName: "POD"}},
+			ContainerName: "POD",
+			Kind:          kubernetesKind}},
 	DockerContainer{dockerId: "65323bda",
 		kubePath: ContainerPath{
 			PodName:       "backend",
 			PodNamespace:  "my-app",
-			ContainerName: "be-server"}},
+			ContainerName: "be-server",
+			Kind:          kubernetesKind}},
 }
 
 func TestParseDockerContainerList(t *testing.T) {
@@ -53,3 +65,110 @@ func TestParseDockerContainerList(t *testing.T) {
 		}
 	}
 }
+
+func TestParseDockerInspect(t *testing.T) {
+	pid, err := parseDockerInspect(strings.NewReader(`{"Id": "56443455", "State": {"Status": "running", "Running": true, "Pid": 36}}`))
+	expectEqual(t, err, nil, "Unexpected error parsing docker inspect output")
+	expectEqual(t, pid, 36, "Unexpected PID from docker inspect output")
+
+	_, err = parseDockerInspect(strings.NewReader(`{"State": `))
+	if err == nil {
+		t.Errorf("Expected an error from truncated docker inspect output")
+	}
+}
+
+func TestListDockerContainers(t *testing.T) {
+	pids := map[string]int{"56443455": 36, "fab8905c": 37, "65323bda": 9486}
+
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Couldn't listen on %v: %v", socket, err)
+	}
+
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/containers/json" {
+			w.Write([]byte(dockerPsOutput))
+			return
+		}
+
+		var id string
+		_, err := fmt.Sscanf(r.URL.Path, "/containers/%8s/json", &id)
+		pid, ok := pids[id]
+		if err != nil || !ok {
+			// a01098fd was deleted after we listed it
+			http.Error(w, `{"message": "No such container"}`, http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, `{"Id": %q, "State": {"Pid": %v}}`, id, pid)
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	containers, err := listDockerContainers(socket)
+	if err != nil {
+		t.Fatalf("Couldn't list Docker containers: %v", err)
+	}
+
+	if len(containers) != len(parsedOutput) {
+		t.Fatalf("Got %v containers, expected %v", len(containers), len(parsedOutput))
+	}
+	for i, expected := range parsedOutput {
+		expectEqual(t, containers[i].id, expected.dockerId, "Unexpected container ID")
+		expectEqual(t, containers[i].kubePath, expected.kubePath, "Unexpected container ContainerPath")
+		expectEqual(t, containers[i].pid, pids[expected.dockerId], "Unexpected container PID")
+	}
+}
+
+func TestParseDockershimName(t *testing.T) {
+	kubePath, podUid, attempt, ok := parseDockershimName("/k8s_fe-server_frontend_my-app_3b8c2a9e-1f4d-4b8e-9a52-6a1e0f3c7d21_2")
+	expectEqual(t, ok, true, "Couldn't parse a dockershim name")
+	expectEqual(t, kubePath, ContainerPath{"my-app", "frontend", "fe-server", kubernetesKind}, "Unexpected ContainerPath from a dockershim name")
+	expectEqual(t, podUid, "3b8c2a9e-1f4d-4b8e-9a52-6a1e0f3c7d21", "Unexpected pod UID from a dockershim name")
+	expectEqual(t, attempt, 2, "Unexpected attempt from a dockershim name")
+
+	for _, name := range []string{
+		"/pensive_turing",
+		"/k8s_fe-server_frontend_my-app_3b8c2a9e_x",
+		"/k8s_fe-server_frontend_my-app_3b8c2a9e",
+		"/app_fe-server_frontend_my-app_3b8c2a9e_0",
+	} {
+		_, _, _, ok := parseDockershimName(name)
+		expectEqual(t, ok, false, "Expected "+name+" not to be a dockershim name")
+	}
+}
+
+func TestParseDockerContainerListWithoutKubeLabels(t *testing.T) {
+	const output = `[
+  {"Id": "56443455", "Names": ["/k8s_fe-server_frontend_my-app_3b8c2a9e-1f4d-4b8e-9a52-6a1e0f3c7d21_3"], "Labels": {}},
+  {"Id": "fab8905c", "Names": ["/k8s_log-shipper_frontend_my-app_3b8c2a9e-1f4d-4b8e-9a52-6a1e0f3c7d21_0"], "Labels": {"io.kubernetes.pod.name": "frontend", "io.kubernetes.pod.namespace": "my-app", "io.kubernetes.container.name": "log-shipper", "io.kubernetes.container.restartCount": "1"}},
+  {"Id": "65323bda", "Names": ["/pensive_turing"], "Image": "alpine:3", "Labels": null},
+  {"Id": "d2e3f4a5", "Names": ["/shop-web-1"], "Image": "shop-web", "Labels": {"com.docker.compose.project": "shop", "com.docker.compose.service": "web"}}
+]`
+
+	got, err := parseDockerContainerList(strings.NewReader(output))
+	if err != nil {
+		t.Fatalf("Couldn't parse Docker container list: %v", err)
+	}
+
+	expected := []DockerContainer{
+		{dockerId: "56443455", podUid: "3b8c2a9e-1f4d-4b8e-9a52-6a1e0f3c7d21", attempt: 3,
+			kubePath: ContainerPath{"my-app", "frontend", "fe-server", kubernetesKind}},
+		// Labels win over the name
+		{dockerId: "fab8905c", podUid: "3b8c2a9e-1f4d-4b8e-9a52-6a1e0f3c7d21", attempt: 1,
+			kubePath: ContainerPath{"my-app", "frontend", "log-shipper", kubernetesKind}},
+		// Containers outside Kubernetes
+		{dockerId: "65323bda", image: "alpine:3",
+			kubePath: ContainerPath{ContainerName: "pensive_turing", Kind: containerKind}},
+		{dockerId: "d2e3f4a5", image: "shop-web",
+			kubePath: ContainerPath{"shop", "web", "shop-web-1", composeKind}},
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("Got %v containers, expected %v", len(got), len(expected))
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("Got container %+v, expected %+v", got[i], expected[i])
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Where kubelet serves its API on each node, and where pods find their
+// service account token and the API server's CA
+const (
+	defaultKubeletUrl              = "https://127.0.0.1:10250"
+	defaultServiceAccountTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	defaultServiceAccountCaFile    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+)
+
+// KubeAPIConfig says how to reach kubelet's API or the API server
+type KubeAPIConfig struct {
+	url        string
+	tokenFile  string // A bearer token to authenticate with, if we don't use a client certificate
+	clientCert string // A client certificate and key to authenticate with
+	clientKey  string
+	caFile     string // The CA that signed the server's certificate. Empty means the system CAs
+	insecure   bool   // Don't verify the server's certificate
+}
+
+// What kubelet tells us about a pod, beyond its namespace and name
+type PodInfo struct {
+	name      string
+	uid       string
+	labels    map[string]string
+	nodeName  string
+	podIP     string
+	ownerKind string // The kind and name of the pod's controller, like ReplicaSet and frontend-7d9f8c6b5, if it has one
+	ownerName string
+
+	ownerWorkload string // The workload that owns the pod's controller, like Deployment/frontend, if the API server told us
+}
+
+// An entry of an object's metadata.ownerReferences
+type ownerReference struct {
+	Kind       string
+	Name       string
+	Controller bool
+}
+
+// The kind and name of the owner that controls an object, or "" if no
+// owner does
+func controllerOf(owners []ownerReference) (kind string, name string) {
+	for _, owner := range owners {
+		if owner.Controller {
+			return owner.Kind, owner.Name
+		}
+	}
+	return "", ""
+}
+
+// Format the pod's controller like "ReplicaSet/frontend-7d9f8c6b5", or
+// return "" if it doesn't have one
+func (pod *PodInfo) owner() string {
+	if pod.ownerKind == "" {
+		return ""
+	}
+	return pod.ownerKind + "/" + pod.ownerName
+}
+
+// The fields we use of kubelet's /pods response, which is a v1.PodList
+type kubeletPodList struct {
+	Items []struct {
+		Metadata struct {
+			Name            string
+			Namespace       string
+			Uid             string
+			Labels          map[string]string
+			OwnerReferences []ownerReference
+		}
+		Spec struct {
+			NodeName string
+		}
+		Status struct {
+			PodIP string
+		}
+	}
+}
+
+// Parse kubelet's /pods response into a map from pods to their
+// PodInfos. The map's keys are ContainerPaths without container names.
+func parseKubeletPods(r io.Reader) (map[ContainerPath]*PodInfo, error) {
+	var podList kubeletPodList
+	err := json.NewDecoder(r).Decode(&podList)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't parse kubelet pod list: %v", err)
+	}
+
+	pods := make(map[ContainerPath]*PodInfo)
+	for _, pod := range podList.Items {
+		info := &PodInfo{
+			name:     pod.Metadata.Name,
+			uid:      pod.Metadata.Uid,
+			labels:   pod.Metadata.Labels,
+			nodeName: pod.Spec.NodeName,
+			podIP:    pod.Status.PodIP,
+		}
+		info.ownerKind, info.ownerName = controllerOf(pod.Metadata.OwnerReferences)
+
+		pods[ContainerPath{PodNamespace: pod.Metadata.Namespace, PodName: pod.Metadata.Name}] = info
+	}
+
+	return pods, nil
+}
+
+// Make an HTTP client that authenticates to kubelet or the API server
+// the way config says, and return it with the Authorization header to send, if any
+func newKubeAPIClient(config KubeAPIConfig) (*http.Client, string, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: config.insecure}
+
+	if config.caFile != "" {
+		caPem, err := os.ReadFile(config.caFile)
+		if err != nil {
+			return nil, "", err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caPem) {
+			return nil, "", fmt.Errorf("Couldn't find any certificates in %v", config.caFile)
+		}
+	}
+
+	var authorization string
+	if config.clientCert != "" {
+		cert, err := tls.LoadX509KeyPair(config.clientCert, config.clientKey)
+		if err != nil {
+			return nil, "", err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	} else if config.tokenFile != "" {
+		token, err := os.ReadFile(config.tokenFile)
+		if err != nil {
+			return nil, "", err
+		}
+		authorization = "Bearer " + strings.TrimSpace(string(token))
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		Timeout:   subprocessTimeout,
+	}
+	return client, authorization, nil
+}
+
+// Get the PodInfos of the pods on this node from kubelet's /pods
+// endpoint
+func fetchKubeletPods(config KubeAPIConfig) (map[ContainerPath]*PodInfo, error) {
+	client, authorization, err := newKubeAPIClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("GET", strings.TrimSuffix(config.url, "/")+"/pods", nil)
+	if err != nil {
+		return nil, err
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("kubelet pod list failed with HTTP status %v", resp.Status)
+	}
+
+	return parseKubeletPods(resp.Body)
+}
+
+// Set the pod of every connection from a pod that kubelet told us about
+func attachPodInfo(connections []KubeConnection, pods map[ContainerPath]*PodInfo) {
+	for i, kc := range connections {
+		connections[i].pod = pods[ContainerPath{
+			PodNamespace: kc.container.PodNamespace,
+			PodName:      kc.container.PodName,
+		}]
+	}
+}
+
+// Format labels like "app=frontend,tier=web", sorted by key
+func formatLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = key + "=" + labels[key]
+	}
+	return strings.Join(pairs, ",")
+}
+
+// Columns made from a pod's PodInfo, which are "" for connections
+// from outside pods
+var podInfoColumns = []partColumn[PodInfo]{
+	{"Pod UID", func(pod *PodInfo) string { return pod.uid }},
+	{"Pod Labels", func(pod *PodInfo) string { return formatLabels(pod.labels) }},
+	{"Node", func(pod *PodInfo) string { return pod.nodeName }},
+	{"Pod IP", func(pod *PodInfo) string { return pod.podIP }},
+	{"Owner", func(pod *PodInfo) string { return pod.owner() }},
+}
+
+// The podInfoColumns, as columns of a table of KubeConnections
+func podInfoConnectionColumns() []kubeConnectionColumn {
+	return partConnectionColumns(podInfoColumns, func(kc *KubeConnection) *PodInfo { return kc.pod })
+}
+
+// The podInfoColumns, as columns of a table of ConnectionCounts
+func podInfoCountColumns() []connectionCountColumn {
+	return partCountColumns(podInfoColumns, func(cc *ConnectionCount) *PodInfo { return cc.pod })
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// This should match the format of kubelet's /pods response. Kubelet
+// prints much more than this.
+const kubeletPods = `{
+  "kind": "PodList",
+  "apiVersion": "v1",
+  "metadata": {},
+  "items": [
+    {
+      "metadata": {
+        "name": "frontend-7d9f8c6b5-x2x4z",
+        "namespace": "my-app",
+        "uid": "3b8c2a9e-1f4d-4b8e-9a52-6a1e0f3c7d21",
+        "labels": {"tier": "web", "app": "frontend"},
+        "ownerReferences": [
+          {"apiVersion": "apps/v1", "kind": "ReplicaSet", "name": "frontend-7d9f8c6b5", "uid": "9e1f", "controller": true}
+        ]
+      },
+      "spec": {"nodeName": "kube-node-1", "containers": [{"name": "fe-server"}]},
+      "status": {"phase": "Running", "podIP": "10.2.9.76"}
+    },
+    {
+      "metadata": {"name": "kube-proxy-abcde", "namespace": "kube-system", "uid": "5d21"},
+      "spec": {"nodeName": "kube-node-1", "hostNetwork": true},
+      "status": {"podIP": "10.240.0.4"}
+    }
+  ]
+}`
+
+var frontendPodPath = ContainerPath{PodNamespace: "my-app", PodName: "frontend-7d9f8c6b5-x2x4z"}
+
+func TestParseKubeletPods(t *testing.T) {
+	pods, err := parseKubeletPods(strings.NewReader(kubeletPods))
+	if err != nil {
+		t.Fatalf("Couldn't parse kubelet pods: %v", err)
+	}
+	expectEqual(t, len(pods), 2, "Unexpected number of pods")
+
+	frontend := pods[frontendPodPath]
+	if frontend == nil {
+		t.Fatalf("Missing frontend pod in %v", pods)
+	}
+	expectEqual(t, frontend.uid, "3b8c2a9e-1f4d-4b8e-9a52-6a1e0f3c7d21", "Unexpected pod UID")
+	expectEqual(t, formatLabels(frontend.labels), "app=frontend,tier=web", "Unexpected pod labels")
+	expectEqual(t, frontend.nodeName, "kube-node-1", "Unexpected node name")
+	expectEqual(t, frontend.podIP, "10.2.9.76", "Unexpected pod IP")
+	expectEqual(t, frontend.owner(), "ReplicaSet/frontend-7d9f8c6b5", "Unexpected pod owner")
+
+	proxy := pods[ContainerPath{PodNamespace: "kube-system", PodName: "kube-proxy-abcde"}]
+	if proxy == nil {
+		t.Fatalf("Missing kube-proxy pod in %v", pods)
+	}
+	expectEqual(t, proxy.owner(), "", "Expected no owner for kube-proxy")
+	expectEqual(t, formatLabels(proxy.labels), "", "Expected no labels for kube-proxy")
+}
+
+// Start a fake kubelet that only answers requests with token, and
+// write its serving certificate to a CA file
+func startFakeKubelet(t *testing.T, token string) (*httptest.Server, string) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/pods" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(kubeletPods))
+	}))
+	t.Cleanup(server.Close)
+
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	caPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	err := os.WriteFile(caFile, caPem, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return server, caFile
+}
+
+func TestFetchKubeletPods(t *testing.T) {
+	server, caFile := startFakeKubelet(t, "s3cret")
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	err := os.WriteFile(tokenFile, []byte("s3cret\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pods, err := fetchKubeletPods(KubeAPIConfig{url: server.URL, tokenFile: tokenFile, caFile: caFile})
+	if err != nil {
+		t.Fatalf("Couldn't fetch kubelet pods: %v", err)
+	}
+	expectEqual(t, len(pods), 2, "Unexpected number of pods from kubelet")
+
+	// Without the CA, we shouldn't trust the fake kubelet
+	_, err = fetchKubeletPods(KubeAPIConfig{url: server.URL, tokenFile: tokenFile})
+	if err == nil {
+		t.Errorf("Expected an error from an unverified kubelet")
+	}
+
+	pods, err = fetchKubeletPods(KubeAPIConfig{url: server.URL, tokenFile: tokenFile, insecure: true})
+	expectEqual(t, err, nil, "Unexpected error with --kubelet-insecure-tls")
+	expectEqual(t, len(pods), 2, "Unexpected number of pods with --kubelet-insecure-tls")
+
+	// Kubelet refuses us without the token
+	_, err = fetchKubeletPods(KubeAPIConfig{url: server.URL, caFile: caFile})
+	if err == nil {
+		t.Errorf("Expected an error without a token")
+	}
+}
+
+func TestAttachPodInfo(t *testing.T) {
+	pods, err := parseKubeletPods(strings.NewReader(kubeletPods))
+	if err != nil {
+		t.Fatalf("Couldn't parse kubelet pods: %v", err)
+	}
+
+	feServer := frontendPodPath
+	feServer.ContainerName = "fe-server"
+	connections := []KubeConnection{
+		{conn: Connection{protocol: "tcp", remoteHost: "10.2.10.82", remotePort: "443"}, container: feServer},
+		{conn: Connection{protocol: "tcp", remoteHost: "10.2.10.82", remotePort: "443"}, container: feServer},
+		{conn: Connection{protocol: "tcp", remoteHost: "10.2.10.82", remotePort: "443"}},
+	}
+	attachPodInfo(connections, pods)
+
+	expectEqual(t, connections[0].pod, pods[frontendPodPath], "Expected the frontend pod's info")
+	if connections[2].pod != nil {
+		t.Errorf("Expected no pod info for a host connection")
+	}
+
+	columns := podInfoConnectionColumns()
+	row := kubeConnectionRow{kc: &connections[0], columns: columns}
+	fields := row.Fields()
+	expectEqual(t, strings.Join(fields[len(fields)-len(columns):], " "),
+		"3b8c2a9e-1f4d-4b8e-9a52-6a1e0f3c7d21 app=frontend,tier=web kube-node-1 10.2.9.76 ReplicaSet/frontend-7d9f8c6b5",
+		"Unexpected pod info fields")
+
+	row = kubeConnectionRow{kc: &connections[2], columns: columns}
+	fields = row.Fields()
+	expectEqual(t, strings.Join(fields[len(fields)-len(columns):], ""), "", "Expected empty pod info fields")
+
+	stats := summarizeKubeConnections(connections)
+	for _, stat := range stats {
+		if stat.connId.container == feServer {
+			expectEqual(t, stat.count, 2, "Unexpected count of frontend connections")
+			expectEqual(t, podInfoCountColumns()[4].field(&stat), "ReplicaSet/frontend-7d9f8c6b5",
+				"Unexpected owner of frontend connections")
+		}
+	}
+}
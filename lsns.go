@@ -4,15 +4,34 @@ import (
 	"bufio"
 	"context"
 	"fmt"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"syscall"
 )
 
 type NamespaceData struct {
-	Ns  int
-	Pid int
+	Ns   int
+	Pid  int    // 0 if no process is in the namespace
+	Path string // A file pinning the namespace, or "" to use Pid
 }
 
+// The file to open to enter the namespace
+func (namespace NamespaceData) nsPath() string {
+	if namespace.Path != "" {
+		return namespace.Path
+	}
+
+	return fmt.Sprintf("/proc/%d/ns/net", namespace.Pid)
+}
+
+// Where `ip netns add` pins net namespaces. Many CNI plugins and
+// container runtimes use these directories too.
+var defaultNetnsDirs = []string{"/var/run/netns", "/run/netns"}
+
 var expectedHeaders = []string{
 	"NS", "PID",
 }
@@ -52,10 +71,146 @@ func parseLsnsOutput(blob []byte) ([]NamespaceData, error) {
 	return result, nil
 }
 
+// List net namespaces by reading the /proc/<pid>/ns/net links under
+// procRoot (normally "/proc"), which look like "net:[4026531992]". Like
+// lsns, we report each namespace with the lowest PID in it, and sort
+// namespaces by inode.
+func listProcNetNamespaces(procRoot string) ([]NamespaceData, error) {
+	entries, err := os.ReadDir(procRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	lowestPids := make(map[int]int)
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			// Not a process directory
+			continue
+		}
+
+		link, err := os.Readlink(filepath.Join(procRoot, entry.Name(), "ns", "net"))
+		if err != nil {
+			// We expect errors here if a process exited
+			// after we listed procRoot, or if it is a
+			// kernel thread
+			continue
+		}
+
+		var ns int
+		_, err = fmt.Sscanf(link, "net:[%d]", &ns)
+		if err != nil {
+			return nil, fmt.Errorf("Unexpected net namespace link %v for PID %v", link, pid)
+		}
+
+		lowest, ok := lowestPids[ns]
+		if !ok || pid < lowest {
+			lowestPids[ns] = pid
+		}
+	}
+
+	result := make([]NamespaceData, 0, len(lowestPids))
+	for ns, pid := range lowestPids {
+		result = append(result, NamespaceData{
+			Ns:  ns,
+			Pid: pid,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Ns < result[j].Ns
+	})
+
+	return result, nil
+}
+
+// List net namespaces that are pinned by bind mounts in netnsDirs,
+// like the ones `ip netns add` makes. Namespaces pinned this way can
+// outlive all of their processes, so processes can't find them. nsfsDev
+// is the device number of the namespace filesystem, which lets us skip
+// files that aren't namespaces. Namespaces whose inodes are in known
+// are skipped too.
+func listPinnedNetNamespaces(netnsDirs []string, nsfsDev uint64, known map[int]bool) ([]NamespaceData, error) {
+	seen := make(map[int]bool)
+	for ns := range known {
+		seen[ns] = true
+	}
+
+	var result []NamespaceData
+	for _, dir := range netnsDirs {
+		entries, err := os.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			path := filepath.Join(dir, entry.Name())
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+
+			// A file that isn't bind mounted to a
+			// namespace is on dir's own filesystem
+			stat, ok := info.Sys().(*syscall.Stat_t)
+			if !ok || uint64(stat.Dev) != nsfsDev {
+				continue
+			}
+
+			ns := int(stat.Ino)
+			if seen[ns] {
+				continue
+			}
+			seen[ns] = true
+
+			result = append(result, NamespaceData{
+				Ns:   ns,
+				Path: path,
+			})
+		}
+	}
+
+	return result, nil
+}
+
+// List net namespaces from /proc, falling back to lsns if that fails,
+// plus the namespaces pinned in netnsDirs
+func listNetNamespaces(netnsDirs []string) ([]NamespaceData, error) {
+	namespaces, err := listProcNetNamespaces("/proc")
+	if err != nil || len(namespaces) == 0 {
+		namespaces, err = listLsnsNetNamespaces()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Our own namespace file tells us which filesystem namespaces
+	// are on
+	info, err := os.Stat("/proc/self/ns/net")
+	if err != nil {
+		return nil, err
+	}
+	nsfsDev := uint64(info.Sys().(*syscall.Stat_t).Dev)
+
+	known := make(map[int]bool)
+	for _, namespace := range namespaces {
+		known[namespace.Ns] = true
+	}
+
+	pinned, err := listPinnedNetNamespaces(netnsDirs, nsfsDev, known)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(namespaces, pinned...), nil
+}
+
 // Run lsns and parse the output.
 // NOTE: if not run as root, lsns will succeed, but not necessarily
 // return all namespaces
-func listNetNamespaces() ([]NamespaceData, error) {
+func listLsnsNetNamespaces() ([]NamespaceData, error) {
 	ctx, _ := context.WithTimeout(context.Background(), subprocessTimeout)
 
 	output, err := exec.CommandContext(ctx, "lsns", "--type", "net", "--output", "ns,pid").Output()
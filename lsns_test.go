@@ -1,6 +1,9 @@
 package main
 
 import (
+	"os"
+	"path/filepath"
+	"syscall"
 	"testing"
 )
 
@@ -38,3 +41,93 @@ func TestParseLsnsOutput(t *testing.T) {
 		}
 	}
 }
+
+func TestListProcNetNamespaces(t *testing.T) {
+	procRoot := t.TempDir()
+
+	// Lay out a fake /proc, where PIDs 1 and 42 share a namespace
+	links := map[string]string{
+		"42":   "net:[4026531992]",
+		"1":    "net:[4026531992]",
+		"3929": "net:[4026532281]",
+		"self": "net:[4026531992]",
+	}
+	for pid, target := range links {
+		nsDir := filepath.Join(procRoot, pid, "ns")
+		if err := os.MkdirAll(nsDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(target, filepath.Join(nsDir, "net")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A kernel thread, with no net namespace link
+	if err := os.MkdirAll(filepath.Join(procRoot, "2", "ns"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	namespaces, err := listProcNetNamespaces(procRoot)
+	if err != nil {
+		t.Fatalf("Got error '%v' from listProcNetNamespaces", err)
+	}
+
+	expected := []NamespaceData{
+		NamespaceData{Ns: 4026531992, Pid: 1},
+		NamespaceData{Ns: 4026532281, Pid: 3929},
+	}
+	if len(namespaces) != len(expected) {
+		t.Fatalf("Got namespaces %v, expected %v", namespaces, expected)
+	}
+	for i := range expected {
+		if namespaces[i] != expected[i] {
+			t.Errorf("Bad namespace %v: expected %v, got %v", i, expected[i], namespaces[i])
+		}
+	}
+}
+
+func TestListPinnedNetNamespaces(t *testing.T) {
+	// Use plain files as stand-ins for bind-mounted namespaces
+	netnsDir := t.TempDir()
+	var inodes []int
+	for _, name := range []string{"cni-1234", "cni-5678"} {
+		path := filepath.Join(netnsDir, name)
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		inodes = append(inodes, int(info.Sys().(*syscall.Stat_t).Ino))
+	}
+	info, err := os.Stat(netnsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev := uint64(info.Sys().(*syscall.Stat_t).Dev)
+
+	// The first namespace also has a process in it, so we should
+	// only report the second one. The same directory listed twice
+	// shouldn't produce duplicates, and a missing directory isn't
+	// an error.
+	known := map[int]bool{inodes[0]: true}
+	dirs := []string{netnsDir, filepath.Join(netnsDir, "missing"), netnsDir}
+	namespaces, err := listPinnedNetNamespaces(dirs, dev, known)
+	if err != nil {
+		t.Fatalf("Got error '%v' from listPinnedNetNamespaces", err)
+	}
+
+	expected := NamespaceData{Ns: inodes[1], Path: filepath.Join(netnsDir, "cni-5678")}
+	if len(namespaces) != 1 || namespaces[0] != expected {
+		t.Fatalf("Got namespaces %v, expected only %v", namespaces, expected)
+	}
+	if namespaces[0].nsPath() != expected.Path {
+		t.Errorf("Expected to enter %v through its pinned path, got %v", expected, namespaces[0].nsPath())
+	}
+
+	// Files on other filesystems aren't namespaces
+	namespaces, err = listPinnedNetNamespaces(dirs, dev+1, nil)
+	if err != nil || len(namespaces) != 0 {
+		t.Errorf("Expected no namespaces on another device, got %v, %v", namespaces, err)
+	}
+}
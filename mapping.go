@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+// One entry of a mapping file. Exactly one of PID, Cgroup, Netns and
+// CIDR says what the entry matches.
+type mappingEntry struct {
+	Pid    int    `json:"pid"`
+	Cgroup string `json:"cgroup"` // A cgroup path, which also matches the cgroups under it
+	Netns  int    `json:"netns"`  // The inode of a net namespace
+	Cidr   string `json:"cidr"`   // Matches TCP and UDP sockets whose local address is in it
+
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+	Container string `json:"container"`
+	Kind      string `json:"kind"` // Defaults to kubernetes for pods, and container otherwise
+}
+
+type cgroupMapping struct {
+	cgroup string
+	path   ContainerPath
+}
+
+type cidrMapping struct {
+	network *net.IPNet
+	path    ContainerPath
+}
+
+// A StaticMapping attributes processes and connections to containers
+// the way a mapping file says, for nodes whose runtime we can't ask,
+// and for tests that need the same output every time
+type StaticMapping struct {
+	pids    map[int]ContainerPath
+	cgroups []cgroupMapping
+	netns   map[int]ContainerPath
+	cidrs   []cidrMapping
+}
+
+// Parse a mapping file. It is a JSON list of entries like
+//
+//	{"pid": 1234, "namespace": "my-app", "pod": "frontend", "container": "fe-server"}
+//	{"cgroup": "/system.slice/legacy.service", "container": "legacy"}
+//	{"netns": 4026532301, "namespace": "my-app", "pod": "backend", "container": "be-server"}
+//	{"cidr": "10.2.9.0/24", "namespace": "my-app", "pod": "frontend", "container": "fe-server"}
+//
+// The file must be JSON; we don't parse YAML. When several cgroup or
+// CIDR entries match, the first one wins.
+func parseMappingFile(r io.Reader) (*StaticMapping, error) {
+	var entries []mappingEntry
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&entries)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't parse mapping file as JSON: %v", err)
+	}
+
+	mapping := &StaticMapping{
+		pids:  make(map[int]ContainerPath),
+		netns: make(map[int]ContainerPath),
+	}
+	for i, entry := range entries {
+		path := ContainerPath{
+			PodNamespace:  entry.Namespace,
+			PodName:       entry.Pod,
+			ContainerName: entry.Container,
+			Kind:          entry.Kind,
+		}
+		if path.Kind == "" && path.PodName != "" {
+			path.Kind = kubernetesKind
+		} else if path.Kind == "" {
+			path.Kind = containerKind
+		}
+
+		selectors := 0
+		if entry.Pid != 0 {
+			selectors += 1
+			mapping.pids[entry.Pid] = path
+		}
+		if entry.Cgroup != "" {
+			selectors += 1
+			mapping.cgroups = append(mapping.cgroups, cgroupMapping{strings.TrimSuffix(entry.Cgroup, "/"), path})
+		}
+		if entry.Netns != 0 {
+			selectors += 1
+			mapping.netns[entry.Netns] = path
+		}
+		if entry.Cidr != "" {
+			selectors += 1
+			_, network, err := net.ParseCIDR(entry.Cidr)
+			if err != nil {
+				return nil, fmt.Errorf("Couldn't parse mapping file entry %d: %v", i, err)
+			}
+			mapping.cidrs = append(mapping.cidrs, cidrMapping{network, path})
+		}
+
+		if selectors != 1 {
+			return nil, fmt.Errorf("Mapping file entry %d must have exactly one of pid, cgroup, netns and cidr", i)
+		}
+	}
+
+	return mapping, nil
+}
+
+func loadMappingFile(filename string) (*StaticMapping, error) {
+	fp, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	return parseMappingFile(fp)
+}
+
+// Find the container of a process from its PID and the paths of its
+// cgroups
+func (mapping *StaticMapping) processPath(pid int, cgroupPaths []string) (ContainerPath, bool) {
+	if path, ok := mapping.pids[pid]; ok {
+		return path, true
+	}
+
+	for _, cgroup := range mapping.cgroups {
+		for _, cgroupPath := range cgroupPaths {
+			if cgroupPath == cgroup.cgroup || strings.HasPrefix(cgroupPath, cgroup.cgroup+"/") {
+				return cgroup.path, true
+			}
+		}
+	}
+
+	return ContainerPath{}, false
+}
+
+// Find the container of a socket from its net namespace or local
+// address
+func (mapping *StaticMapping) socketPath(netns int, localHost string) (ContainerPath, bool) {
+	if path, ok := mapping.netns[netns]; ok {
+		return path, true
+	}
+
+	ip := net.ParseIP(localHost)
+	if ip == nil {
+		return ContainerPath{}, false
+	}
+	for _, cidr := range mapping.cidrs {
+		if cidr.network.Contains(ip) {
+			return cidr.path, true
+		}
+	}
+
+	return ContainerPath{}, false
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const mappingFile = `[
+  {"pid": 36, "namespace": "my-app", "pod": "frontend", "container": "fe-server"},
+  {"cgroup": "/system.slice/legacy.service/", "container": "legacy"},
+  {"netns": 4026532301, "namespace": "my-app", "pod": "backend", "container": "be-server", "kind": "podman-pod"},
+  {"cidr": "10.2.9.76/32", "namespace": "my-app", "pod": "frontend", "container": "fe-server"},
+  {"cidr": "10.2.9.0/24", "namespace": "my-app", "pod": "other"}
+]`
+
+func TestParseMappingFile(t *testing.T) {
+	mapping, err := parseMappingFile(strings.NewReader(mappingFile))
+	if err != nil {
+		t.Fatalf("Couldn't parse mapping file: %v", err)
+	}
+
+	feServer := ContainerPath{"my-app", "frontend", "fe-server", kubernetesKind}
+	expectEqual(t, mapping.pids[36], feServer, "Unexpected PID mapping")
+	expectEqual(t, mapping.netns[4026532301], ContainerPath{"my-app", "backend", "be-server", podmanPodKind},
+		"Unexpected net namespace mapping")
+	expectEqual(t, len(mapping.cgroups), 1, "Unexpected number of cgroup mappings")
+	expectEqual(t, mapping.cgroups[0].path, ContainerPath{"", "", "legacy", containerKind}, "Unexpected cgroup mapping")
+	expectEqual(t, len(mapping.cidrs), 2, "Unexpected number of CIDR mappings")
+
+	for _, bad := range []string{
+		`[{"pid": 36, "netns": 4026532301, "container": "two"}]`,
+		`[{"container": "none"}]`,
+		`[{"cidr": "10.2.9.0/33", "container": "bad"}]`,
+		`[{"pids": 36, "container": "typo"}]`,
+		`{"pid": 36}`,
+		"- pid: 36\n  container: yaml\n",
+	} {
+		_, err := parseMappingFile(strings.NewReader(bad))
+		if err == nil {
+			t.Errorf("Expected an error from mapping file %v", bad)
+		}
+	}
+}
+
+func TestStaticMappingLookups(t *testing.T) {
+	mapping, err := parseMappingFile(strings.NewReader(mappingFile))
+	if err != nil {
+		t.Fatalf("Couldn't parse mapping file: %v", err)
+	}
+
+	legacy := ContainerPath{"", "", "legacy", containerKind}
+	for _, test := range []struct {
+		pid      int
+		cgroups  []string
+		expected ContainerPath
+		ok       bool
+	}{
+		{36, []string{"/init.scope"}, ContainerPath{"my-app", "frontend", "fe-server", kubernetesKind}, true},
+		{37, []string{"/system.slice/legacy.service"}, legacy, true},
+		{38, []string{"/system.slice/legacy.service/worker"}, legacy, true},
+		{39, []string{"/system.slice/legacy.service2"}, ContainerPath{}, false},
+		{40, nil, ContainerPath{}, false},
+	} {
+		path, ok := mapping.processPath(test.pid, test.cgroups)
+		expectEqual(t, ok, test.ok, "Unexpected match of PID "+strings.Join(test.cgroups, ","))
+		expectEqual(t, path, test.expected, "Unexpected container of PID "+strings.Join(test.cgroups, ","))
+	}
+
+	for _, test := range []struct {
+		conn     Connection
+		expected string
+	}{
+		{Connection{localHost: "10.2.9.20", netns: 4026532301}, "be-server"},
+		{Connection{localHost: "10.2.9.76"}, "fe-server"},
+		{Connection{localHost: "::ffff:10.2.9.76"}, "fe-server"},
+		{Connection{localHost: "10.2.9.20"}, ""},
+		{Connection{localHost: "10.2.10.82"}, ""},
+		{Connection{localHost: "localhost"}, ""},
+	} {
+		path, _ := mapping.socketPath(test.conn.netns, test.conn.localHost)
+		expectEqual(t, path.ContainerName, test.expected, "Unexpected container of connection from "+test.conn.localHost)
+	}
+}
+
+func TestPodResolverWithMappingFile(t *testing.T) {
+	procRoot := fakeProcCgroups(t, map[int]string{
+		36:   "/kubepods/burstable/pod" + frontendPodUid + "/" + feServerId,
+		1:    "/init.scope",
+		7000: "/system.slice/legacy.service",
+	})
+
+	mapping, err := parseMappingFile(strings.NewReader(`[
+	  {"pid": 36, "namespace": "override", "pod": "frontend", "container": "fe-server"},
+	  {"cgroup": "/system.slice/legacy.service", "container": "legacy"},
+	  {"netns": 4026532301, "namespace": "my-app", "pod": "backend", "container": "be-server"}
+	]`))
+	if err != nil {
+		t.Fatalf("Couldn't parse mapping file: %v", err)
+	}
+
+	resolver := newPodResolver(procRoot, []RuntimeContainer{
+		{id: feServerId, pid: 36, podUid: frontendPodUid, kubePath: ContainerPath{"my-app", "frontend", "fe-server", kubernetesKind}},
+	})
+	resolver.static = mapping
+
+	backend := ContainerPath{"my-app", "backend", "be-server", kubernetesKind}
+	for _, test := range []struct {
+		conn     Connection
+		expected ContainerPath
+	}{
+		// The mapping file overrides the runtime
+		{Connection{pid: 36}, ContainerPath{"override", "frontend", "fe-server", kubernetesKind}},
+		{Connection{pid: 7000}, ContainerPath{"", "", "legacy", containerKind}},
+		// A TIME_WAIT connection, and a process that the
+		// runtimes don't know about, in a mapped namespace
+		{Connection{pid: 0, netns: 4026532301}, backend},
+		{Connection{pid: 1, netns: 4026532301}, backend},
+		{Connection{pid: 1, netns: 4026531840}, ContainerPath{Kind: hostKind}},
+	} {
+		expectEqual(t, resolver.socketToPod(test.conn.pid, test.conn.netns, test.conn.localHost), test.expected, "Unexpected container of connection")
+	}
+	expectEqual(t, resolver.missedContainers, false, "Host processes aren't containers the runtimes missed")
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// Constants from linux/sock_diag.h and linux/inet_diag.h that the
+// syscall package doesn't define
+const (
+	sockDiagByFamily = 20
+
+	sizeofInetDiagReqV2 = 56
+	sizeofInetDiagMsg   = 72
+
+	// Every TCP state, as a bitmask of 1 << state
+	allTcpStates uint32 = 0xfff
+
+	// The attribute type of struct tcp_info in replies, and the
+	// bit that asks for it in requests
+	inetDiagInfo          = 2
+	inetDiagInfoExtension = 1 << (inetDiagInfo - 1)
+
+	// Align netlink attributes to 4 bytes
+	rtaAlignTo = 4
+)
+
+// Per-connection statistics from the kernel's struct tcp_info
+type TCPInfo struct {
+	rtt           uint32 // Smoothed round trip time, in microseconds
+	retransmits   uint32 // Total retransmitted segments
+	sndCwnd       uint32 // Congestion window, in segments
+	bytesAcked    uint64
+	bytesReceived uint64
+}
+
+// Offsets of the fields we use in struct tcp_info. Its layout is part
+// of the kernel ABI; newer kernels only add fields to the end.
+const (
+	tcpInfoRttOffset           = 68
+	tcpInfoSndCwndOffset       = 80
+	tcpInfoTotalRetransOffset  = 100
+	tcpInfoBytesAckedOffset    = 120
+	tcpInfoBytesReceivedOffset = 128
+	tcpInfoMinLen              = 136
+)
+
+// Parse a struct tcp_info
+func parseTcpInfo(data []byte) (*TCPInfo, error) {
+	if len(data) < tcpInfoMinLen {
+		return nil, fmt.Errorf("Short tcp_info: %v bytes", len(data))
+	}
+
+	return &TCPInfo{
+		rtt:           binary.NativeEndian.Uint32(data[tcpInfoRttOffset:]),
+		retransmits:   binary.NativeEndian.Uint32(data[tcpInfoTotalRetransOffset:]),
+		sndCwnd:       binary.NativeEndian.Uint32(data[tcpInfoSndCwndOffset:]),
+		bytesAcked:    binary.NativeEndian.Uint64(data[tcpInfoBytesAckedOffset:]),
+		bytesReceived: binary.NativeEndian.Uint64(data[tcpInfoBytesReceivedOffset:]),
+	}, nil
+}
+
+// Find the tcp_info attribute in the attributes after a struct
+// inet_diag_msg, and parse it. Returns nil if there isn't one.
+func parseInetDiagAttributes(attrs []byte) (*TCPInfo, error) {
+	for len(attrs) >= syscall.SizeofRtAttr {
+		attrLen := int(binary.NativeEndian.Uint16(attrs[0:2]))
+		attrType := binary.NativeEndian.Uint16(attrs[2:4])
+		if attrLen < syscall.SizeofRtAttr || attrLen > len(attrs) {
+			return nil, fmt.Errorf("Bad inet_diag attribute length %v", attrLen)
+		}
+
+		if attrType == inetDiagInfo {
+			return parseTcpInfo(attrs[syscall.SizeofRtAttr:attrLen])
+		}
+
+		alignedLen := (attrLen + rtaAlignTo - 1) &^ (rtaAlignTo - 1)
+		if alignedLen >= len(attrs) {
+			break
+		}
+		attrs = attrs[alignedLen:]
+	}
+
+	return nil, nil
+}
+
+// Convert a list of state names like "TIME_WAIT" into the bitmask that
+// inet_diag requests use. An empty list means every state.
+func tcpStateMask(states []string) (uint32, error) {
+	if len(states) == 0 {
+		return allTcpStates, nil
+	}
+
+	var mask uint32
+	for _, state := range states {
+		found := false
+		for num, name := range tcpStates {
+			if name == state {
+				mask |= 1 << num
+				found = true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("Unknown TCP state %v", state)
+		}
+	}
+
+	return mask, nil
+}
+
+// Build a netlink message asking for a dump of all sockets of one
+// address family and protocol whose states are in stateMask. If
+// withTcpInfo is set, ask for each socket's struct tcp_info too.
+func inetDiagRequest(family uint8, protocol uint8, stateMask uint32, withTcpInfo bool) []byte {
+	buf := make([]byte, syscall.NLMSG_HDRLEN+sizeofInetDiagReqV2)
+
+	// struct nlmsghdr
+	binary.NativeEndian.PutUint32(buf[0:4], uint32(len(buf)))
+	binary.NativeEndian.PutUint16(buf[4:6], sockDiagByFamily)
+	binary.NativeEndian.PutUint16(buf[6:8], syscall.NLM_F_REQUEST|syscall.NLM_F_DUMP)
+
+	// struct inet_diag_req_v2. We leave the socket ID zeroed,
+	// which matches every socket.
+	req := buf[syscall.NLMSG_HDRLEN:]
+	req[0] = family
+	req[1] = protocol
+	if withTcpInfo {
+		req[2] = inetDiagInfoExtension
+	}
+	binary.NativeEndian.PutUint32(req[4:8], stateMask)
+
+	return buf
+}
+
+// Parse one struct inet_diag_msg for a socket of protocol ("tcp" or
+// "udp"), and the attributes that follow it, into a Connection. Like
+// parseProcNetOutput, this sets the Connection's inode rather than its
+// pid.
+func parseInetDiagMsg(data []byte, protocol string) (Connection, error) {
+	if len(data) < sizeofInetDiagMsg {
+		return Connection{}, fmt.Errorf("Short inet_diag message: %v bytes", len(data))
+	}
+
+	family := data[0]
+	state := uint64(data[1])
+
+	var ipLen int
+	switch family {
+	case syscall.AF_INET:
+		ipLen = net.IPv4len
+	case syscall.AF_INET6:
+		protocol += "6"
+		ipLen = net.IPv6len
+	default:
+		return Connection{}, fmt.Errorf("Unexpected address family %v in inet_diag message", family)
+	}
+
+	// struct inet_diag_sockid starts at offset 4. Ports and
+	// addresses are in network byte order.
+	id := data[4:]
+	localPort := binary.BigEndian.Uint16(id[0:2])
+	remotePort := binary.BigEndian.Uint16(id[2:4])
+	localIp := net.IP(append([]byte(nil), id[4:4+ipLen]...))
+	remoteIp := net.IP(append([]byte(nil), id[20:20+ipLen]...))
+
+	// Servers have no remote port. netstat calls it "*".
+	remotePortStr := "*"
+	if remotePort != 0 {
+		remotePortStr = strconv.Itoa(int(remotePort))
+	}
+
+	tcpInfo, err := parseInetDiagAttributes(data[sizeofInetDiagMsg:])
+	if err != nil {
+		return Connection{}, err
+	}
+
+	conn := Connection{
+		protocol:        protocol,
+		localHost:       localIp.String(),
+		localPort:       strconv.Itoa(int(localPort)),
+		remoteHost:      remoteIp.String(),
+		remotePort:      remotePortStr,
+		connectionState: socketStateName(protocol, state),
+		recvQ:           int(binary.NativeEndian.Uint32(data[56:60])),
+		sendQ:           int(binary.NativeEndian.Uint32(data[60:64])),
+		inode:           uint64(binary.NativeEndian.Uint32(data[68:72])),
+		tcpInfo:         tcpInfo,
+	}
+
+	// For listening sockets, idiag_wqueue is the backlog limit
+	// rather than queued bytes. /proc/net/tcp says 0.
+	if conn.connectionState == "LISTEN" {
+		conn.sendQ = 0
+	}
+
+	return conn, nil
+}
+
+// Parse one buffer of netlink replies to an inet_diag dump of protocol
+// sockets, keeping the ones mode includes. done is true if the buffer
+// held the end of the dump.
+func parseInetDiagResponse(buf []byte, protocol string, mode ServerMode) (conns []Connection, done bool, err error) {
+	messages, err := syscall.ParseNetlinkMessage(buf)
+	if err != nil {
+		return nil, false, err
+	}
+
+	for _, message := range messages {
+		switch message.Header.Type {
+		case syscall.NLMSG_DONE:
+			return conns, true, nil
+		case syscall.NLMSG_ERROR:
+			if len(message.Data) < 4 {
+				return nil, false, fmt.Errorf("Short netlink error message")
+			}
+			errno := -int32(binary.NativeEndian.Uint32(message.Data[0:4]))
+			return nil, false, fmt.Errorf("inet_diag request failed: %v", syscall.Errno(errno))
+		case sockDiagByFamily:
+			conn, err := parseInetDiagMsg(message.Data, protocol)
+			if err != nil {
+				return nil, false, err
+			}
+
+			if mode.includes(conn) {
+				conns = append(conns, conn)
+			}
+		}
+	}
+
+	return conns, false, nil
+}
+
+// The IP protocol numbers of the protocols we support
+var ipProtocols = map[string]uint8{
+	"tcp": syscall.IPPROTO_TCP,
+	"udp": syscall.IPPROTO_UDP,
+}
+
+// Dump all sockets of one protocol ("tcp" or "udp") and address family
+// from the current thread's net namespace
+func inetDiagDump(protocol string, family uint8, stateMask uint32, mode ServerMode, withTcpInfo bool) ([]Connection, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_INET_DIAG)
+	if err != nil {
+		return nil, err
+	}
+	defer syscall.Close(fd)
+
+	err = syscall.Sendto(fd, inetDiagRequest(family, ipProtocols[protocol], stateMask, withTcpInfo && protocol == "tcp"), 0,
+		&syscall.SockaddrNetlink{Family: syscall.AF_NETLINK})
+	if err != nil {
+		return nil, err
+	}
+
+	var result []Connection
+	buf := make([]byte, 8*os.Getpagesize())
+	for {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		conns, done, err := parseInetDiagResponse(buf[:n], protocol, mode)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, conns...)
+
+		if done {
+			return result, nil
+		}
+	}
+}
+
+// Get open connections of the given protocols ("tcp" and/or "udp")
+// from the namespace at nsPath with NETLINK_SOCK_DIAG. Only sockets whose
+// states are in stateMask are returned; the kernel does the
+// filtering. owners maps socket inodes to PIDs, as returned by
+// socketInodeOwners. mode says whether to include servers. If
+// withTcpInfo is set, TCP connections will have their tcpInfo set.
+func getNetlinkConnectionsFromNamespace(nsPath string, owners map[uint64]int, protocols []string, stateMask uint32, mode ServerMode, withTcpInfo bool) ([]Connection, error) {
+	var result []Connection
+
+	err := inNetNamespace(nsPath, func() error {
+		for _, protocol := range protocols {
+			for _, family := range []uint8{syscall.AF_INET, syscall.AF_INET6} {
+				conns, err := inetDiagDump(protocol, family, stateMask, mode, withTcpInfo)
+				if err != nil {
+					return err
+				}
+
+				result = append(result, conns...)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range result {
+		result[i].pid = owners[result[i].inode]
+	}
+
+	return result, nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/binary"
+	"syscall"
+	"testing"
+)
+
+func TestTcpStateMask(t *testing.T) {
+	mask, err := tcpStateMask(nil)
+	if err != nil || mask != allTcpStates {
+		t.Errorf("Expected all states from an empty list, got %x, %v", mask, err)
+	}
+
+	mask, err = tcpStateMask([]string{"ESTABLISHED", "TIME_WAIT"})
+	if err != nil || mask != (1<<1|1<<6) {
+		t.Errorf("Got mask %x, %v for ESTABLISHED,TIME_WAIT", mask, err)
+	}
+
+	_, err = tcpStateMask([]string{"NOT_A_STATE"})
+	if err == nil {
+		t.Errorf("Expected an error from an unknown state")
+	}
+}
+
+// Build a netlink message with type msgType and payload data
+func netlinkMessage(msgType uint16, data []byte) []byte {
+	buf := make([]byte, syscall.NLMSG_HDRLEN+len(data))
+	binary.NativeEndian.PutUint32(buf[0:4], uint32(len(buf)))
+	binary.NativeEndian.PutUint16(buf[4:6], msgType)
+	copy(buf[syscall.NLMSG_HDRLEN:], data)
+	return buf
+}
+
+// Build a struct inet_diag_msg for an IPv4 socket
+func inetDiagMsg(state uint8, localIp, remoteIp [4]byte, localPort, remotePort uint16, inode uint32) []byte {
+	msg := make([]byte, sizeofInetDiagMsg)
+	msg[0] = syscall.AF_INET
+	msg[1] = state
+	binary.BigEndian.PutUint16(msg[4:6], localPort)
+	binary.BigEndian.PutUint16(msg[6:8], remotePort)
+	copy(msg[8:12], localIp[:])
+	copy(msg[24:28], remoteIp[:])
+	binary.NativeEndian.PutUint32(msg[68:72], inode)
+	return msg
+}
+
+func TestParseInetDiagResponse(t *testing.T) {
+	var buf []byte
+	buf = append(buf, netlinkMessage(sockDiagByFamily,
+		inetDiagMsg(1, [4]byte{10, 0, 1, 5}, [4]byte{10, 3, 0, 4}, 35406, 443, 35468))...)
+	// A listening socket, which should be skipped
+	buf = append(buf, netlinkMessage(sockDiagByFamily,
+		inetDiagMsg(10, [4]byte{0, 0, 0, 0}, [4]byte{0, 0, 0, 0}, 8080, 0, 21916))...)
+	buf = append(buf, netlinkMessage(sockDiagByFamily,
+		inetDiagMsg(6, [4]byte{10, 0, 1, 5}, [4]byte{10, 0, 0, 2}, 45986, 443, 0))...)
+	buf = append(buf, netlinkMessage(syscall.NLMSG_DONE, make([]byte, 4))...)
+
+	conns, done, err := parseInetDiagResponse(buf, "tcp", withoutServers)
+	if err != nil {
+		t.Fatalf("Got error %v from parseInetDiagResponse", err)
+	}
+	if !done {
+		t.Errorf("parseInetDiagResponse didn't see NLMSG_DONE")
+	}
+
+	expected := []Connection{
+		Connection{protocol: "tcp",
+			localHost:       "10.0.1.5",
+			localPort:       "35406",
+			remoteHost:      "10.3.0.4",
+			remotePort:      "443",
+			connectionState: "ESTABLISHED",
+			inode:           35468},
+		Connection{protocol: "tcp",
+			localHost:       "10.0.1.5",
+			localPort:       "45986",
+			remoteHost:      "10.0.0.2",
+			remotePort:      "443",
+			connectionState: "TIME_WAIT",
+			inode:           0},
+	}
+
+	if len(conns) != len(expected) {
+		t.Fatalf("Got %v connections, expected %v", len(conns), len(expected))
+	}
+	for i := range expected {
+		if conns[i] != expected[i] {
+			t.Errorf("Got connection %v, expected %v", conns[i], expected[i])
+		}
+	}
+}
+
+func TestParseInetDiagError(t *testing.T) {
+	errMsg := make([]byte, syscall.SizeofNlMsgerr)
+	errno := -int32(syscall.EINVAL)
+	binary.NativeEndian.PutUint32(errMsg[0:4], uint32(errno))
+
+	_, _, err := parseInetDiagResponse(netlinkMessage(syscall.NLMSG_ERROR, errMsg), "tcp", withoutServers)
+	if err == nil {
+		t.Errorf("Expected an error from an NLMSG_ERROR reply")
+	}
+}
+
+func TestParseInetDiagListenQueues(t *testing.T) {
+	// A listening socket with 3 connections waiting to be
+	// accepted, and a backlog of 1024
+	msg := inetDiagMsg(10, [4]byte{0, 0, 0, 0}, [4]byte{0, 0, 0, 0}, 8080, 0, 21916)
+	binary.NativeEndian.PutUint32(msg[56:60], 3)
+	binary.NativeEndian.PutUint32(msg[60:64], 1024)
+
+	conns, _, err := parseInetDiagResponse(netlinkMessage(sockDiagByFamily, msg), "tcp", withServers)
+	if err != nil {
+		t.Fatalf("Got error %v from parseInetDiagResponse", err)
+	}
+	if len(conns) != 1 {
+		t.Fatalf("Got connections %v, expected one", conns)
+	}
+	expectEqual(t, conns[0].recvQ, 3, "Expected the accept queue as Recv-Q")
+	expectEqual(t, conns[0].sendQ, 0, "Expected no Send-Q for a listening socket, like /proc/net/tcp")
+}
+
+func TestParseInetDiagUdp(t *testing.T) {
+	buf := netlinkMessage(sockDiagByFamily,
+		inetDiagMsg(7, [4]byte{10, 0, 1, 5}, [4]byte{10, 0, 0, 11}, 5353, 5353, 18113))
+
+	conns, _, err := parseInetDiagResponse(buf, "udp", withoutServers)
+	if err != nil {
+		t.Fatalf("Got error %v from parseInetDiagResponse", err)
+	}
+
+	expected := Connection{protocol: "udp",
+		localHost:       "10.0.1.5",
+		localPort:       "5353",
+		remoteHost:      "10.0.0.11",
+		remotePort:      "5353",
+		connectionState: "",
+		inode:           18113}
+	if len(conns) != 1 || conns[0] != expected {
+		t.Errorf("Got connections %v, expected only %v", conns, expected)
+	}
+}
+
+func TestParseInetDiagTcpInfo(t *testing.T) {
+	info := make([]byte, 232)
+	binary.NativeEndian.PutUint32(info[tcpInfoRttOffset:], 1520)
+	binary.NativeEndian.PutUint32(info[tcpInfoSndCwndOffset:], 10)
+	binary.NativeEndian.PutUint32(info[tcpInfoTotalRetransOffset:], 3)
+	binary.NativeEndian.PutUint64(info[tcpInfoBytesAckedOffset:], 48213)
+	binary.NativeEndian.PutUint64(info[tcpInfoBytesReceivedOffset:], 9051)
+
+	// An attribute we don't care about, then INET_DIAG_INFO
+	attrs := make([]byte, syscall.SizeofRtAttr+4)
+	binary.NativeEndian.PutUint16(attrs[0:2], uint16(len(attrs)))
+	binary.NativeEndian.PutUint16(attrs[2:4], 5)
+	infoAttr := make([]byte, syscall.SizeofRtAttr)
+	binary.NativeEndian.PutUint16(infoAttr[0:2], uint16(syscall.SizeofRtAttr+len(info)))
+	binary.NativeEndian.PutUint16(infoAttr[2:4], inetDiagInfo)
+	attrs = append(attrs, append(infoAttr, info...)...)
+
+	msg := inetDiagMsg(1, [4]byte{10, 0, 1, 5}, [4]byte{10, 3, 0, 4}, 35406, 443, 35468)
+	conns, _, err := parseInetDiagResponse(netlinkMessage(sockDiagByFamily, append(msg, attrs...)), "tcp", withoutServers)
+	if err != nil {
+		t.Fatalf("Got error %v from parseInetDiagResponse", err)
+	}
+	if len(conns) != 1 || conns[0].tcpInfo == nil {
+		t.Fatalf("Expected one connection with tcp_info, got %v", conns)
+	}
+
+	expected := TCPInfo{rtt: 1520, retransmits: 3, sndCwnd: 10, bytesAcked: 48213, bytesReceived: 9051}
+	if *conns[0].tcpInfo != expected {
+		t.Errorf("Got tcp_info %v, expected %v", *conns[0].tcpInfo, expected)
+	}
+}
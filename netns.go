@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// Move the calling thread into the net namespace referred to by fd
+func setns(fd uintptr) error {
+	_, _, errno := syscall.RawSyscall(sysSetns, fd, syscall.CLONE_NEWNET, 0)
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+// Run f inside the net namespace referred to by nsPath, like
+// "/proc/<pid>/ns/net" or a bind mount under /var/run/netns, and
+// return its error.
+//
+// Namespaces belong to threads, not processes, so f runs on its own
+// goroutine locked to an OS thread. If we can't move that thread back
+// to our original namespace afterwards, we leave it locked, and the Go
+// runtime will throw the thread away when the goroutine exits. f must
+// not start goroutines of its own, because they could run on other
+// threads, in other namespaces.
+func inNetNamespace(nsPath string, f func() error) error {
+	result := make(chan error, 1)
+
+	go func() {
+		runtime.LockOSThread()
+
+		origNs, err := os.Open("/proc/thread-self/ns/net")
+		if err != nil {
+			runtime.UnlockOSThread()
+			result <- err
+			return
+		}
+		defer origNs.Close()
+
+		targetNs, err := os.Open(nsPath)
+		if err != nil {
+			runtime.UnlockOSThread()
+			result <- err
+			return
+		}
+		defer targetNs.Close()
+
+		err = setns(targetNs.Fd())
+		if err != nil {
+			runtime.UnlockOSThread()
+			result <- fmt.Errorf("Couldn't enter net namespace %v: %v", nsPath, err)
+			return
+		}
+
+		fErr := f()
+
+		err = setns(origNs.Fd())
+		if err != nil {
+			result <- fmt.Errorf("Couldn't leave net namespace %v: %v", nsPath, err)
+			return
+		}
+
+		runtime.UnlockOSThread()
+		result <- fErr
+	}()
+
+	return <-result
+}
@@ -12,13 +12,24 @@ import (
 
 // A connection as returned by netstat (also as seen by the kernel)
 type Connection struct {
-	protocol        string // Either "tcp" or "tcp6"
-	localHost       string // Either an IP address or a hostname
-	localPort       string // Either a number or a well-known protocol like "http"
-	remoteHost      string // Like localHost
-	remotePort      string // Like localPort
-	connectionState string // "ESTABLISHED", "TIME_WAIT", etc.
-	pid             int    // 0 if unknown. Connections in TIME_WAIT will have a zero pid
+	protocol        string   // One of "tcp", "tcp6", "udp" or "udp6"
+	localHost       string   // Either an IP address or a hostname
+	localPort       string   // Either a number or a well-known protocol like "http"
+	remoteHost      string   // Like localHost
+	remotePort      string   // Like localPort
+	connectionState string   // "ESTABLISHED", "TIME_WAIT", etc.
+	recvQ           int      // Bytes received but not yet read by the process
+	sendQ           int      // Bytes sent but not yet acknowledged by the remote side
+	pid             int      // 0 if unknown. Connections in TIME_WAIT will have a zero pid
+	inode           uint64   // The socket's inode, or 0 if unknown
+	tcpInfo         *TCPInfo // nil unless we asked the kernel for it
+	netns           int      // The inode of the socket's net namespace
+}
+
+// Is conn a server, i.e. a listening TCP socket or an unconnected UDP
+// socket? Servers have no remote port.
+func (conn Connection) isServer() bool {
+	return conn.remotePort == "*"
 }
 
 // Split a netstat address into a host and a port. An address can be
@@ -36,16 +47,35 @@ func hostAndPort(address string) (string, string, error) {
 	return address[:split], address[split+1:], nil
 }
 
-// Parse the output of 'sudo netstat --tcp --program'
+// Is protocol one of the UDP protocols, "udp" or "udp6"?
+func isUdp(protocol string) bool {
+	return strings.HasPrefix(protocol, "udp")
+}
+
+// Parse the output of 'sudo netstat --tcp --udp --program'
 var expectedHeaderFields = []string{
 	"Proto", "Recv-Q", "Send-Q", "Local", "Address", "Foreign",
 	"Address", "State", "PID/Program", "name"}
 
+// The first line of netstat output, which depends on whether we passed
+// --listening or --all
+var expectedBanners = []string{
+	"Active Internet connections (w/o servers)",
+	"Active Internet connections (only servers)",
+	"Active Internet connections (servers and established)",
+}
+
 func parseNetstatOutput(output io.Reader) ([]Connection, error) {
 	lines := bufio.NewScanner(output)
 
 	lines.Scan()
-	if lines.Text() != "Active Internet connections (w/o servers)" {
+	knownBanner := false
+	for _, banner := range expectedBanners {
+		if lines.Text() == banner {
+			knownBanner = true
+		}
+	}
+	if !knownBanner {
 		return nil, fmt.Errorf("Unexpected line 1 of netstat output: %s", lines.Text())
 	}
 
@@ -57,18 +87,37 @@ func parseNetstatOutput(output io.Reader) ([]Connection, error) {
 
 	var result []Connection
 	for lines.Scan() {
-		var proto, recv_q, send_q, local_address, remote_address, state, pid_name string
-		// There may be extra space-separated groups at the
-		// end of the line. Deliberately ignore them.
-		n, err := fmt.Sscan(lines.Text(), &proto, &recv_q, &send_q, &local_address,
-			&remote_address, &state, &pid_name)
-		if n < 7 {
+		fields := strings.Fields(lines.Text())
+		if len(fields) < 6 {
 			return nil, fmt.Errorf("Couldn't scan netstat output line: %s", lines.Text())
 		}
+		proto, local_address, remote_address := fields[0], fields[3], fields[4]
+
+		recvQ, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		sendQ, err := strconv.Atoi(fields[2])
 		if err != nil {
 			return nil, err
 		}
 
+		// UDP sockets only have a state if they are
+		// connected. Otherwise the PID/Program name column
+		// comes right after the addresses.
+		var state, pid_name string
+		rest := fields[5:]
+		if !(isUdp(proto) && (rest[0] == "-" || strings.Contains(rest[0], "/"))) {
+			state = rest[0]
+			rest = rest[1:]
+		}
+		if len(rest) == 0 {
+			return nil, fmt.Errorf("Couldn't scan netstat output line: %s", lines.Text())
+		}
+		// There may be extra space-separated groups at the
+		// end of the line. Deliberately ignore them.
+		pid_name = rest[0]
+
 		localHost, localPort, err := hostAndPort(local_address)
 		if err != nil {
 			return nil, err
@@ -97,6 +146,8 @@ func parseNetstatOutput(output io.Reader) ([]Connection, error) {
 			remoteHost:      remoteHost,
 			remotePort:      remotePort,
 			connectionState: state,
+			recvQ:           recvQ,
+			sendQ:           sendQ,
 			pid:             pid,
 		})
 	}
@@ -104,11 +155,28 @@ func parseNetstatOutput(output io.Reader) ([]Connection, error) {
 	return result, nil
 }
 
-// Get open TCP connections from the namespace of pid, in the format of parseNetstatOutput
-func getConnectionsFromNamespace(pid string) ([]Connection, error) {
+// Get open connections of the given protocols ("tcp" and/or "udp")
+// from the namespace at nsPath, in the format of parseNetstatOutput.
+// mode says whether to include servers. If numeric is false, netstat
+// resolves hosts and ports to names.
+func getConnectionsFromNamespace(nsPath string, protocols []string, mode ServerMode, numeric bool) ([]Connection, error) {
 	ctx, _ := context.WithTimeout(context.Background(), subprocessTimeout)
 
-	netstatOutput, err := exec.CommandContext(ctx, "nsenter", "-t", pid, "-n", "netstat", "--tcp", "--program").Output()
+	args := []string{"--net=" + nsPath, "netstat", "--program"}
+	for _, protocol := range protocols {
+		args = append(args, "--"+protocol)
+	}
+	switch mode {
+	case onlyServers:
+		args = append(args, "--listening")
+	case withServers:
+		args = append(args, "--all")
+	}
+	if numeric {
+		args = append(args, "--numeric")
+	}
+
+	netstatOutput, err := exec.CommandContext(ctx, "nsenter", args...).Output()
 	if err != nil {
 		return nil, err
 	}
@@ -117,3 +117,83 @@ func TestParseNetstatOutput(t *testing.T) {
 		}
 	}
 }
+
+// This should match the output format of 'sudo netstat --udp --program'.
+// Unconnected UDP sockets have no state.
+
+const netstatUdpOutput = `Active Internet connections (w/o servers)
+Proto Recv-Q Send-Q Local Address           Foreign Address         State       PID/Program name
+udp        0      0 10.0.1.5:48121          10.0.0.10:53            ESTABLISHED 36/coredns
+udp        0      0 10.0.1.5:5353           10.0.0.11:5353                      512/avahi-daemon: r
+udp6       0      0 fe80::1:546             fe80::2:547                         -`
+
+var netstatUdpExpectedParse = [3]Connection{
+	Connection{protocol: "udp",
+		localHost:       "10.0.1.5",
+		localPort:       "48121",
+		remoteHost:      "10.0.0.10",
+		remotePort:      "53",
+		connectionState: "ESTABLISHED",
+		pid:             36},
+	Connection{protocol: "udp",
+		localHost:       "10.0.1.5",
+		localPort:       "5353",
+		remoteHost:      "10.0.0.11",
+		remotePort:      "5353",
+		connectionState: "",
+		pid:             512},
+	Connection{protocol: "udp6",
+		localHost:       "fe80::1",
+		localPort:       "546",
+		remoteHost:      "fe80::2",
+		remotePort:      "547",
+		connectionState: "",
+		pid:             0},
+}
+
+func TestParseNetstatUdpOutput(t *testing.T) {
+	connections, err := parseNetstatOutput(strings.NewReader(netstatUdpOutput))
+
+	if err != nil {
+		t.Logf("Got error %v from parse_netstat_output", err)
+		t.FailNow()
+	}
+
+	if len(connections) != len(netstatUdpExpectedParse) {
+		t.Logf("Got %v connections, expected %v", len(connections), len(netstatUdpExpectedParse))
+		t.FailNow()
+	}
+
+	for i, expected := range netstatUdpExpectedParse {
+		if expected != connections[i] {
+			t.Errorf("Got connection %v, expected %v", connections[i], expected)
+		}
+	}
+}
+
+// This should match the output format of 'sudo netstat --tcp --listening --program'
+
+const netstatListeningOutput = `Active Internet connections (only servers)
+Proto Recv-Q Send-Q Local Address           Foreign Address         State       PID/Program name
+tcp        0      0 0.0.0.0:8080            0.0.0.0:*               LISTEN      4821/envoy`
+
+func TestParseNetstatListeningOutput(t *testing.T) {
+	connections, err := parseNetstatOutput(strings.NewReader(netstatListeningOutput))
+	if err != nil {
+		t.Fatalf("Got error %v from parseNetstatOutput", err)
+	}
+
+	expected := Connection{protocol: "tcp",
+		localHost:       "0.0.0.0",
+		localPort:       "8080",
+		remoteHost:      "0.0.0.0",
+		remotePort:      "*",
+		connectionState: "LISTEN",
+		pid:             4821}
+	if len(connections) != 1 || connections[0] != expected {
+		t.Errorf("Got connections %v, expected only %v", connections, expected)
+	}
+	if !connections[0].isServer() {
+		t.Errorf("Expected %v to be a server", connections[0])
+	}
+}
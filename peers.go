@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net"
+	"strings"
+)
+
+// The addresses of one socket of a connection, as the socket sees them
+type socketAddresses struct {
+	protocol   string // "tcp" or "udp", without distinguishing IPv6
+	localHost  string
+	localPort  string
+	remoteHost string
+	remotePort string
+}
+
+// The addresses of conn's socket, with canonical IPs. ok is false if
+// either host isn't an IP address, which happens when we resolve names.
+func connectionAddresses(conn Connection) (addresses socketAddresses, ok bool) {
+	addresses = socketAddresses{
+		protocol:   strings.TrimSuffix(conn.protocol, "6"),
+		localHost:  canonicalIP(conn.localHost),
+		localPort:  conn.localPort,
+		remoteHost: canonicalIP(conn.remoteHost),
+		remotePort: conn.remotePort,
+	}
+	return addresses, addresses.localHost != "" && addresses.remoteHost != ""
+}
+
+// The addresses of the socket at the other end of the connection
+func (addresses socketAddresses) mirror() socketAddresses {
+	return socketAddresses{
+		protocol:   addresses.protocol,
+		localHost:  addresses.remoteHost,
+		localPort:  addresses.remotePort,
+		remoteHost: addresses.localHost,
+		remotePort: addresses.localPort,
+	}
+}
+
+// Loopback addresses only mean something inside one net namespace
+func (addresses socketAddresses) isLoopback() bool {
+	return net.ParseIP(addresses.localHost).IsLoopback() || net.ParseIP(addresses.remoteHost).IsLoopback()
+}
+
+// Find both ends of connections between two sockets on this node, and
+// set each end's peer to the container of the other. The two sockets
+// are usually in different net namespaces, like two pods talking to
+// each other, and see the same addresses mirrored: one socket's local
+// address is the other's remote address. Pod IPs are unique on a node,
+// but every namespace has its own loopback addresses, so we only match
+// loopback connections within a namespace. We leave connections alone
+// if more than one socket could be their peer.
+func joinPeers(connections []KubeConnection) {
+	sockets := make(map[socketAddresses][]int)
+	for i, kc := range connections {
+		if kc.conn.isServer() {
+			continue
+		}
+		addresses, ok := connectionAddresses(kc.conn)
+		if ok {
+			sockets[addresses] = append(sockets[addresses], i)
+		}
+	}
+
+	for addresses, ends := range sockets {
+		if len(ends) != 1 {
+			continue
+		}
+		peers := sockets[addresses.mirror()]
+		if len(peers) != 1 {
+			continue
+		}
+
+		end, peer := &connections[ends[0]], &connections[peers[0]]
+		if addresses.isLoopback() && end.conn.netns != peer.conn.netns {
+			continue
+		}
+		end.peer = peer.container
+	}
+}
+
+// Columns made from the ContainerPath at the other end of a connection
+var peerColumns = []partColumn[ContainerPath]{
+	{"Peer Namespace", func(peer *ContainerPath) string { return peer.PodNamespace }},
+	{"Peer Pod", func(peer *ContainerPath) string { return peer.PodName }},
+	{"Peer Container", func(peer *ContainerPath) string { return peer.ContainerName }},
+	{"Peer Kind", func(peer *ContainerPath) string { return peer.Kind }},
+}
+
+// The peerColumns, as columns of a table of KubeConnections
+func peerConnectionColumns() []kubeConnectionColumn {
+	return partConnectionColumns(peerColumns, func(kc *KubeConnection) *ContainerPath { return &kc.peer })
+}
+
+// The peerColumns, as columns of a table of ConnectionCounts
+func peerCountColumns() []connectionCountColumn {
+	return partCountColumns(peerColumns, func(cc *ConnectionCount) *ContainerPath { return &cc.peer })
+}
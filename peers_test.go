@@ -0,0 +1,92 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestJoinPeers(t *testing.T) {
+	frontend := ContainerPath{"my-app", "frontend", "fe-server", kubernetesKind}
+	backend := ContainerPath{"my-app", "backend", "be-server", kubernetesKind}
+	sidecar := ContainerPath{"my-app", "frontend", "sidecar", kubernetesKind}
+	other := ContainerPath{"other-app", "worker", "worker", kubernetesKind}
+
+	connections := []KubeConnection{
+		// frontend -> backend, seen from both pods. The backend
+		// listens on an IPv6 socket.
+		{conn: Connection{protocol: "tcp", localHost: "10.2.9.76", localPort: "40312", remoteHost: "10.2.10.82", remotePort: "8080", netns: 1},
+			container: frontend},
+		{conn: Connection{protocol: "tcp6", localHost: "::ffff:10.2.10.82", localPort: "8080", remoteHost: "::ffff:10.2.9.76", remotePort: "40312", netns: 2},
+			container: backend},
+		// frontend -> something off the node
+		{conn: Connection{protocol: "tcp", localHost: "10.2.9.76", localPort: "40313", remoteHost: "93.184.216.34", remotePort: "443", netns: 1},
+			container: frontend},
+		// A loopback connection within a pod, and mirrored
+		// loopback addresses in two different pods, which
+		// aren't a connection
+		{conn: Connection{protocol: "tcp", localHost: "127.0.0.1", localPort: "51000", remoteHost: "127.0.0.1", remotePort: "9090", netns: 1},
+			container: frontend},
+		{conn: Connection{protocol: "tcp", localHost: "127.0.0.1", localPort: "9090", remoteHost: "127.0.0.1", remotePort: "51000", netns: 1},
+			container: sidecar},
+		{conn: Connection{protocol: "tcp", localHost: "127.0.0.1", localPort: "52000", remoteHost: "127.0.0.1", remotePort: "9090", netns: 3},
+			container: other},
+		{conn: Connection{protocol: "tcp", localHost: "127.0.0.1", localPort: "9090", remoteHost: "127.0.0.1", remotePort: "52000", netns: 4},
+			container: frontend},
+		// A server has no peer
+		{conn: Connection{protocol: "tcp", localHost: "10.2.10.82", localPort: "8080", remoteHost: "0.0.0.0", remotePort: "*", netns: 2},
+			container: backend},
+	}
+	joinPeers(connections)
+
+	for i, expected := range []ContainerPath{
+		backend,
+		frontend,
+		{},
+		sidecar,
+		frontend,
+		{},
+		{},
+		{},
+	} {
+		expectEqual(t, connections[i].peer, expected, "Unexpected peer of connection "+strings.Join(connections[i].Fields(), " "))
+	}
+
+	columns := peerConnectionColumns()
+	fields := kubeConnectionRow{kc: &connections[0], columns: columns}.Fields()
+	expectEqual(t, strings.Join(fields[len(fields)-len(columns):], " "), "my-app backend be-server kubernetes",
+		"Unexpected peer fields")
+}
+
+func TestJoinPeersAmbiguous(t *testing.T) {
+	// Two sockets with the same addresses, like the same 4-tuple
+	// reused in two namespaces behind NAT
+	frontend := ContainerPath{"my-app", "frontend", "fe-server", kubernetesKind}
+	connections := []KubeConnection{
+		{conn: Connection{protocol: "tcp", localHost: "10.2.9.76", localPort: "40312", remoteHost: "10.2.10.82", remotePort: "8080", netns: 1},
+			container: frontend},
+		{conn: Connection{protocol: "tcp", localHost: "10.2.9.76", localPort: "40312", remoteHost: "10.2.10.82", remotePort: "8080", netns: 5},
+			container: frontend},
+		{conn: Connection{protocol: "tcp", localHost: "10.2.10.82", localPort: "8080", remoteHost: "10.2.9.76", remotePort: "40312", netns: 2},
+			container: ContainerPath{"my-app", "backend", "be-server", kubernetesKind}},
+	}
+	joinPeers(connections)
+
+	for _, kc := range connections {
+		expectEqual(t, kc.peer, ContainerPath{}, "Expected no peer for an ambiguous connection")
+	}
+}
+
+func TestSummarizePeers(t *testing.T) {
+	frontend := ContainerPath{"my-app", "frontend", "fe-server", kubernetesKind}
+	backend := ContainerPath{"my-app", "backend", "be-server", kubernetesKind}
+	connections := []KubeConnection{
+		{conn: Connection{protocol: "tcp", localHost: "10.2.9.76", localPort: "40312", remoteHost: "10.2.10.82", remotePort: "8080"},
+			container: frontend},
+		{conn: Connection{protocol: "tcp", localHost: "10.2.9.76", localPort: "40313", remoteHost: "10.2.10.82", remotePort: "8080"},
+			container: frontend, peer: backend},
+	}
+
+	stats := summarizeKubeConnections(connections)
+	expectEqual(t, len(stats), 1, "Unexpected number of summary rows")
+	expectEqual(t, peerCountColumns()[1].field(&stats[0]), "backend", "Expected the peer of the connection that has one")
+}
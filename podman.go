@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Where Podman's API service listens when it runs as root
+const defaultPodmanSocket = "/run/podman/podman.sock"
+
+// The fields we use of each container in Podman's container list. See
+// https://docs.podman.io/en/latest/_static/api.html#tag/containers/operation/ContainerListLibpod
+type podmanContainer struct {
+	Id      string
+	Names   []string
+	Image   string
+	Pid     int
+	Labels  map[string]string
+	PodName string
+	IsInfra bool
+}
+
+// Parse the JSON container list from Podman's libpod API into
+// RuntimeContainers.
+//
+// Containers that kubelet started have the usual Kubernetes labels.
+// Containers in pods from `podman kube play` or `podman pod create`
+// don't, so we use Podman's pod name, and its container name without
+// the pod name prefix that `podman kube play` adds. Like Docker's POD
+// containers, each pod's infra container is called POD. Other
+// containers get a plainContainerPath.
+func parsePodmanContainerList(r io.Reader) ([]RuntimeContainer, error) {
+	var containers []podmanContainer
+	err := json.NewDecoder(r).Decode(&containers)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't parse Podman container list: %v", err)
+	}
+
+	var result []RuntimeContainer
+	for _, container := range containers {
+		kubePath := kubePathFromLabels(container.Labels)
+
+		if kubePath == (ContainerPath{}) && container.PodName != "" {
+			kubePath.PodName = container.PodName
+			kubePath.Kind = podmanPodKind
+			if container.IsInfra {
+				kubePath.ContainerName = "POD"
+			} else if len(container.Names) > 0 {
+				kubePath.ContainerName = strings.TrimPrefix(container.Names[0], container.PodName+"-")
+			}
+		} else if kubePath == (ContainerPath{}) && len(container.Names) > 0 {
+			kubePath = plainContainerPath(container.Names[0], container.Labels)
+		}
+
+		result = append(result, RuntimeContainer{
+			id:       container.Id,
+			pid:      container.Pid,
+			podUid:   container.Labels[podUidLabel],
+			image:    container.Image,
+			kubePath: kubePath,
+		})
+	}
+
+	return result, nil
+}
+
+// List the running containers of the Podman API service listening on
+// socket, with their PIDs
+func listPodmanContainers(socket string) ([]RuntimeContainer, error) {
+	client := &http.Client{Transport: unixSocketTransport(socket), Timeout: subprocessTimeout}
+
+	// The libpod API only lists running containers unless we ask
+	// for all of them
+	resp, err := client.Get("http://podman/v4.0.0/libpod/containers/json")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Podman container list failed with HTTP status %v", resp.Status)
+	}
+
+	return parsePodmanContainerList(resp.Body)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// This should match the output of GET /libpod/containers/json. Podman
+// prints more fields than this.
+const podmanContainerList = `[
+  {"Id": "56443455", "Names": ["frontend-fe-server"], "Pid": 36, "PodName": "frontend", "IsInfra": false,
+   "Labels": {"component": "foo,bar"}},
+  {"Id": "a01098fd", "Names": ["3b8c2a9e1f4d-infra"], "Pid": 35, "PodName": "frontend", "IsInfra": true,
+   "Labels": null},
+  {"Id": "65323bda", "Names": ["k8s_be-server_backend_my-app_0"], "Pid": 9486, "PodName": "",
+   "Labels": {"io.kubernetes.pod.name": "backend", "io.kubernetes.pod.namespace": "my-app", "io.kubernetes.container.name": "be-server"}},
+  {"Id": "fab8905c", "Names": ["toolbox"], "Pid": 5000, "PodName": ""}
+]`
+
+var parsedPodmanContainers = []RuntimeContainer{
+	{id: "56443455", pid: 36, kubePath: ContainerPath{"", "frontend", "fe-server", podmanPodKind}},
+	{id: "a01098fd", pid: 35, kubePath: ContainerPath{"", "frontend", "POD", podmanPodKind}},
+	{id: "65323bda", pid: 9486, kubePath: ContainerPath{"my-app", "backend", "be-server", kubernetesKind}},
+	{id: "fab8905c", pid: 5000, kubePath: ContainerPath{"", "", "toolbox", containerKind}},
+}
+
+func TestParsePodmanContainerList(t *testing.T) {
+	got, err := parsePodmanContainerList(strings.NewReader(podmanContainerList))
+	if err != nil {
+		t.Fatalf("Couldn't parse Podman container list: %v", err)
+	}
+
+	if len(got) != len(parsedPodmanContainers) {
+		t.Fatalf("Got %v containers, expected %v", len(got), len(parsedPodmanContainers))
+	}
+	for i, expected := range parsedPodmanContainers {
+		if got[i] != expected {
+			t.Errorf("Got container %+v, expected %+v", got[i], expected)
+		}
+	}
+
+	_, err = parsePodmanContainerList(strings.NewReader("not json"))
+	if err == nil {
+		t.Errorf("Expected an error from a bad container list")
+	}
+}
+
+func TestListPodmanContainers(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "podman.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Couldn't listen on %v: %v", socket, err)
+	}
+
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v4.0.0/libpod/containers/json" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(podmanContainerList))
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	got, err := listPodmanContainers(socket)
+	if err != nil {
+		t.Fatalf("Couldn't list Podman containers: %v", err)
+	}
+	expectEqual(t, len(got), len(parsedPodmanContainers), "Unexpected number of Podman containers")
+}
@@ -2,8 +2,11 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
+	"strings"
 )
 
 type Fielder interface {
@@ -74,10 +77,11 @@ func printJsonTable(rows []Fielder, fieldNames []string, f io.Writer) {
 	for _, row := range rows {
 		w.WriteString("{")
 		for i, field := range row.Fields() {
-			// Using fprintf with a buffered writer
-			// results in two buffers, but this still
-			// seems better than the alternatives.
-			fmt.Fprintf(w, "\"%s\": \"%s\"", fieldNames[i], field)
+			// Fields can be Unix socket paths, which
+			// can have any characters in them
+			w.WriteString(jsonString(fieldNames[i]))
+			w.WriteString(": ")
+			w.WriteString(jsonString(field))
 			if i < (len(fieldNames) - 1) {
 				w.WriteString(", ")
 			}
@@ -87,3 +91,34 @@ func printJsonTable(rows []Fielder, fieldNames []string, f io.Writer) {
 
 	w.Flush()
 }
+
+// Quote s as a JSON string. Unlike json.Marshal, this leaves <, > and &
+// alone, since we don't print JSON into HTML.
+func jsonString(s string) string {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(s)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// A row with a timestamp in front of its fields
+type timestampedRow struct {
+	timestamp string
+	row       Fielder
+}
+
+func (row timestampedRow) Fields() []string {
+	return append([]string{row.timestamp}, row.row.Fields()...)
+}
+
+// Add a Timestamp field to the front of each row of a table, so that
+// the rows of periodic JSON snapshots say which snapshot they're from
+func addTimestamps(rows []Fielder, header []string, timestamp string) ([]Fielder, []string) {
+	timestamped := make([]Fielder, len(rows))
+	for i, row := range rows {
+		timestamped[i] = timestampedRow{timestamp: timestamp, row: row}
+	}
+
+	return timestamped, append([]string{"Timestamp"}, header...)
+}
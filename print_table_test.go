@@ -53,3 +53,19 @@ func testPrintJsonTable(t *testing.T) {
 		t.Errorf("printJsonTable wrote %#v, expected %#v", written, expectedJson)
 	}
 }
+
+const expectedTimestampedJson = `{"Timestamp": "2026-10-17T12:00:05Z", "AAA": "a", "B": "b", "C": "cc"}
+{"Timestamp": "2026-10-17T12:00:05Z", "AAA": "aaa", "B": "b", "C": "c"}
+{"Timestamp": "2026-10-17T12:00:05Z", "AAA": "A", "B": "", "C": "c"}
+`
+
+func TestAddTimestamps(t *testing.T) {
+	var buf bytes.Buffer
+
+	table, fields := addTimestamps(testTable, testFields, "2026-10-17T12:00:05Z")
+	printJsonTable(table, fields, &buf)
+	written := buf.String()
+	if written != expectedTimestampedJson {
+		t.Errorf("printJsonTable wrote %#v, expected %#v", written, expectedTimestampedJson)
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// TCP states as numbered in /proc/net/tcp, named the way netstat
+// names them
+var tcpStates = map[uint64]string{
+	0x01: "ESTABLISHED",
+	0x02: "SYN_SENT",
+	0x03: "SYN_RECV",
+	0x04: "FIN_WAIT1",
+	0x05: "FIN_WAIT2",
+	0x06: "TIME_WAIT",
+	0x07: "CLOSE",
+	0x08: "CLOSE_WAIT",
+	0x09: "LAST_ACK",
+	0x0A: "LISTEN",
+	0x0B: "CLOSING",
+	0x0C: "NEW_SYN_RECV",
+}
+
+// Parse an address from /proc/net/tcp into a host and a port. Addresses
+// look like
+//
+//	0100007F:0CEA
+//
+// for IPv4 and
+//
+//	00000000000000000000000001000000:0CEA
+//
+// for IPv6. The kernel prints the IP address as a series of 32-bit
+// words in host byte order, and the port as a number in hex.
+func parseProcNetAddress(address string) (string, string, error) {
+	parts := strings.Split(address, ":")
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("Couldn't parse /proc/net address %v", address)
+	}
+
+	hexIp := parts[0]
+	if len(hexIp) != 2*net.IPv4len && len(hexIp) != 2*net.IPv6len {
+		return "", "", fmt.Errorf("Bad IP address length in /proc/net address %v", address)
+	}
+
+	ip := make(net.IP, len(hexIp)/2)
+	for i := 0; i < len(ip); i += 4 {
+		word, err := strconv.ParseUint(hexIp[2*i:2*i+8], 16, 32)
+		if err != nil {
+			return "", "", err
+		}
+		binary.NativeEndian.PutUint32(ip[i:], uint32(word))
+	}
+
+	port, err := strconv.ParseUint(parts[1], 16, 16)
+	if err != nil {
+		return "", "", err
+	}
+
+	return ip.String(), strconv.FormatUint(port, 10), nil
+}
+
+// Name the state of a socket from its number in /proc/net or
+// inet_diag. UDP sockets reuse the TCP state numbers, but netstat only
+// names their ESTABLISHED state.
+func socketStateName(protocol string, state uint64) string {
+	if isUdp(protocol) {
+		switch state {
+		case 0x01:
+			return "ESTABLISHED"
+		case 0x07:
+			return ""
+		default:
+			return "UNKNOWN"
+		}
+	}
+
+	name, ok := tcpStates[state]
+	if !ok {
+		return fmt.Sprintf("UNKNOWN(%d)", state)
+	}
+	return name
+}
+
+// Parse the contents of one of /proc/net/{tcp,tcp6,udp,udp6}. protocol
+// is the protocol to record in the resulting Connections. The pids of
+// the Connections will be 0, and their inodes will be set instead.
+// mode says whether to include servers, like netstat's --listening and
+// --all.
+func parseProcNetOutput(output io.Reader, protocol string, mode ServerMode) ([]Connection, error) {
+	lines := bufio.NewScanner(output)
+
+	// The first line is a header
+	lines.Scan()
+	if !strings.HasPrefix(strings.TrimSpace(lines.Text()), "sl") {
+		return nil, fmt.Errorf("Unexpected header of /proc/net/%s: %s", protocol, lines.Text())
+	}
+
+	var result []Connection
+	for lines.Scan() {
+		fields := strings.Fields(lines.Text())
+		if len(fields) < 10 {
+			return nil, fmt.Errorf("Couldn't parse /proc/net/%s line: %s", protocol, lines.Text())
+		}
+
+		localHost, localPort, err := parseProcNetAddress(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		remoteHost, remotePort, err := parseProcNetAddress(fields[2])
+		if err != nil {
+			return nil, err
+		}
+
+		// Servers have no remote port. netstat calls it "*".
+		if remotePort == "0" {
+			remotePort = "*"
+		}
+
+		stateNum, err := strconv.ParseUint(fields[3], 16, 8)
+		if err != nil {
+			return nil, err
+		}
+		state := socketStateName(protocol, stateNum)
+
+		var sendQ, recvQ int
+		_, err = fmt.Sscanf(fields[4], "%x:%x", &sendQ, &recvQ)
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't parse queues in /proc/net/%s line: %s", protocol, lines.Text())
+		}
+
+		inode, err := strconv.ParseUint(fields[9], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		conn := Connection{
+			protocol:        protocol,
+			localHost:       localHost,
+			localPort:       localPort,
+			remoteHost:      remoteHost,
+			remotePort:      remotePort,
+			connectionState: state,
+			recvQ:           recvQ,
+			sendQ:           sendQ,
+			inode:           inode,
+		}
+		if mode.includes(conn) {
+			result = append(result, conn)
+		}
+	}
+
+	if err := lines.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Find the PIDs that own each socket on the system, by reading the
+// file descriptor symlinks under procRoot (normally "/proc"). Socket
+// inodes are unique across net namespaces, so one map serves every
+// namespace. If several processes share a socket, we return one of
+// them.
+func socketInodeOwners(procRoot string) (map[uint64]int, error) {
+	entries, err := os.ReadDir(procRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	owners := make(map[uint64]int)
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			// Not a process directory
+			continue
+		}
+
+		fdDir := filepath.Join(procRoot, entry.Name(), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			// We expect errors here if a process exited
+			// after we listed procRoot.
+			continue
+		}
+
+		for _, fd := range fds {
+			target, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil {
+				continue
+			}
+
+			var inode uint64
+			_, err = fmt.Sscanf(target, "socket:[%d]", &inode)
+			if err != nil {
+				continue
+			}
+
+			if _, ok := owners[inode]; !ok {
+				owners[inode] = pid
+			}
+		}
+	}
+
+	return owners, nil
+}
+
+// The files in /proc/net that list sockets of each protocol we
+// support. They are named after the protocols, the same way netstat
+// names them.
+var procNetFiles = map[string][]string{
+	"tcp": {"tcp", "tcp6"},
+	"udp": {"udp", "udp6"},
+}
+
+// Get open connections of the given protocols ("tcp" and/or "udp") from
+// the namespace at nsPath by reading /proc/net directly. owners maps socket
+// inodes to PIDs, as returned by socketInodeOwners. mode says whether to
+// include servers.
+func getProcConnectionsFromNamespace(nsPath string, owners map[uint64]int, protocols []string, mode ServerMode) ([]Connection, error) {
+	var result []Connection
+
+	err := inNetNamespace(nsPath, func() error {
+		for _, protocol := range protocols {
+			for _, file := range procNetFiles[protocol] {
+				// /proc/net is a link to /proc/self/net,
+				// which shows the namespace of our main
+				// thread. We need the namespace of this
+				// thread.
+				fp, err := os.Open("/proc/thread-self/net/" + file)
+				if os.IsNotExist(err) {
+					// tcp6 and udp6 don't exist if
+					// IPv6 is disabled
+					continue
+				}
+				if err != nil {
+					return err
+				}
+
+				conns, err := parseProcNetOutput(fp, file, mode)
+				fp.Close()
+				if err != nil {
+					return err
+				}
+
+				result = append(result, conns...)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range result {
+		result[i].pid = owners[result[i].inode]
+	}
+
+	return result, nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseProcNetAddress(t *testing.T) {
+	host, port, _ := parseProcNetAddress("0100007F:0CEA")
+	expectEqual(t, host, "127.0.0.1", "Unexpected host from 0100007F:0CEA")
+	expectEqual(t, port, "3306", "Unexpected port from 0100007F:0CEA")
+
+	host, port, _ = parseProcNetAddress("00000000000000000000000001000000:01BB")
+	expectEqual(t, host, "::1", "Unexpected host from 00000000000000000000000001000000:01BB")
+	expectEqual(t, port, "443", "Unexpected port from 00000000000000000000000001000000:01BB")
+
+	_, _, err := parseProcNetAddress("0100007F")
+	if err == nil {
+		t.Errorf("Expected an error from an address with no port")
+	}
+}
+
+// This should match the format of /proc/net/tcp
+const procNetTcpOutput = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 0100007F:0CEA 00000000:0000 0A 00000000:00000000 00:00000000 00000000   998        0 21916 1 0000000000000000 100 0 0 10 0
+   1: 0501000A:B3A2 0200000A:01BB 06 00000000:00000000 03:00001587 00000000     0        0 0 3 0000000000000000
+   2: 0501000A:8A4E 0400030A:01BB 01 000001A0:00000020 02:000009A2 00000000  1000        0 35468 2 0000000000000000 20 4 30 10 -1
+`
+
+var procNetTcpExpectedParse = []Connection{
+	Connection{protocol: "tcp",
+		localHost:       "10.0.1.5",
+		localPort:       "45986",
+		remoteHost:      "10.0.0.2",
+		remotePort:      "443",
+		connectionState: "TIME_WAIT",
+		inode:           0},
+	Connection{protocol: "tcp",
+		localHost:       "10.0.1.5",
+		localPort:       "35406",
+		remoteHost:      "10.3.0.4",
+		remotePort:      "443",
+		connectionState: "ESTABLISHED",
+		recvQ:           32,
+		sendQ:           416,
+		inode:           35468},
+}
+
+func TestParseProcNetOutput(t *testing.T) {
+	connections, err := parseProcNetOutput(strings.NewReader(procNetTcpOutput), "tcp", withoutServers)
+	if err != nil {
+		t.Logf("Got error %v from parseProcNetOutput", err)
+		t.FailNow()
+	}
+
+	if len(connections) != len(procNetTcpExpectedParse) {
+		t.Logf("Got %v connections, expected %v", len(connections), len(procNetTcpExpectedParse))
+		t.FailNow()
+	}
+
+	for i, expected := range procNetTcpExpectedParse {
+		if expected != connections[i] {
+			t.Errorf("Got connection %v, expected %v", connections[i], expected)
+		}
+	}
+}
+
+func TestParseProcNetOutputServers(t *testing.T) {
+	connections, err := parseProcNetOutput(strings.NewReader(procNetTcpOutput), "tcp", onlyServers)
+	if err != nil {
+		t.Fatalf("Got error %v from parseProcNetOutput", err)
+	}
+
+	expected := Connection{protocol: "tcp",
+		localHost:       "127.0.0.1",
+		localPort:       "3306",
+		remoteHost:      "0.0.0.0",
+		remotePort:      "*",
+		connectionState: "LISTEN",
+		inode:           21916}
+	if len(connections) != 1 || connections[0] != expected {
+		t.Errorf("Got connections %v, expected only %v", connections, expected)
+	}
+
+	connections, err = parseProcNetOutput(strings.NewReader(procNetTcpOutput), "tcp", withServers)
+	if err != nil {
+		t.Fatalf("Got error %v from parseProcNetOutput", err)
+	}
+	if len(connections) != 3 {
+		t.Errorf("Got %v connections with servers, expected 3", len(connections))
+	}
+}
+
+// This should match the format of /proc/net/udp
+const procNetUdpOutput = `   sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops
+  219: 00000000:0044 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 18113 2 0000000000000000 0
+  842: 0501000A:BBF9 0A00000A:0035 01 00000000:00000000 00:00000000 00000000   101        0 46221 2 0000000000000000 0
+`
+
+func TestParseProcNetUdpOutput(t *testing.T) {
+	connections, err := parseProcNetOutput(strings.NewReader(procNetUdpOutput), "udp", withoutServers)
+	if err != nil {
+		t.Fatalf("Got error %v from parseProcNetOutput", err)
+	}
+
+	expected := Connection{protocol: "udp",
+		localHost:       "10.0.1.5",
+		localPort:       "48121",
+		remoteHost:      "10.0.0.10",
+		remotePort:      "53",
+		connectionState: "ESTABLISHED",
+		inode:           46221}
+	if len(connections) != 1 || connections[0] != expected {
+		t.Errorf("Got connections %v, expected only %v", connections, expected)
+	}
+}
+
+func TestSocketInodeOwners(t *testing.T) {
+	procRoot := t.TempDir()
+
+	// Lay out a fake /proc with two processes sharing a socket
+	fds := map[string]map[string]string{
+		"36":   {"0": "/dev/null", "3": "socket:[35468]", "4": "pipe:[1234]"},
+		"9486": {"5": "socket:[21916]"},
+		"self": {"3": "socket:[99999]"},
+	}
+	for pid, links := range fds {
+		fdDir := filepath.Join(procRoot, pid, "fd")
+		if err := os.MkdirAll(fdDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for fd, target := range links {
+			if err := os.Symlink(target, filepath.Join(fdDir, fd)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	owners, err := socketInodeOwners(procRoot)
+	if err != nil {
+		t.Fatalf("Got error %v from socketInodeOwners", err)
+	}
+
+	expected := map[uint64]int{35468: 36, 21916: 9486}
+	if fmt.Sprint(owners) != fmt.Sprint(expected) {
+		t.Errorf("Got socket owners %v, expected %v", owners, expected)
+	}
+}
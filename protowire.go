@@ -0,0 +1,135 @@
+package main
+
+// Just enough of the protocol buffers wire format to talk to container
+// runtimes over gRPC, without depending on a protobuf library. See
+// https://protobuf.dev/programming-guides/encoding/
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Protobuf wire types
+const (
+	protoVarint = 0
+	protoI64    = 1
+	protoLen    = 2
+	protoI32    = 5
+)
+
+func protoAppendVarint(b []byte, v uint64) []byte {
+	return binary.AppendUvarint(b, v)
+}
+
+func protoAppendTag(b []byte, field int, wireType int) []byte {
+	return protoAppendVarint(b, uint64(field)<<3|uint64(wireType))
+}
+
+// Append a varint field, like an integer, bool or enum
+func protoAppendUint(b []byte, field int, v uint64) []byte {
+	b = protoAppendTag(b, field, protoVarint)
+	return protoAppendVarint(b, v)
+}
+
+func protoAppendBool(b []byte, field int, v bool) []byte {
+	if v {
+		return protoAppendUint(b, field, 1)
+	}
+	return protoAppendUint(b, field, 0)
+}
+
+// Append a length-delimited field, like bytes or an embedded message
+func protoAppendBytes(b []byte, field int, v []byte) []byte {
+	b = protoAppendTag(b, field, protoLen)
+	b = protoAppendVarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func protoAppendString(b []byte, field int, v string) []byte {
+	return protoAppendBytes(b, field, []byte(v))
+}
+
+// Append one entry of a map<string, string> field. Maps are encoded as
+// repeated messages with the key in field 1 and the value in field 2.
+func protoAppendMapEntry(b []byte, field int, key, value string) []byte {
+	var entry []byte
+	entry = protoAppendString(entry, 1, key)
+	entry = protoAppendString(entry, 2, value)
+	return protoAppendBytes(b, field, entry)
+}
+
+// A ProtoField is one field of an encoded message. Varint fields have
+// their value in varint, and length-delimited fields have it in bytes.
+// We don't need the values of fixed-width fields.
+type ProtoField struct {
+	number   int
+	wireType int
+	varint   uint64
+	bytes    []byte
+}
+
+// Split an encoded message into its fields, in the order they appear
+func protoParse(b []byte) ([]ProtoField, error) {
+	var result []ProtoField
+
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, fmt.Errorf("Bad protobuf tag")
+		}
+		b = b[n:]
+
+		field := ProtoField{number: int(tag >> 3), wireType: int(tag & 7)}
+		switch field.wireType {
+		case protoVarint:
+			field.varint, n = binary.Uvarint(b)
+			if n <= 0 {
+				return nil, fmt.Errorf("Bad protobuf varint in field %v", field.number)
+			}
+			b = b[n:]
+		case protoLen:
+			length, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < length {
+				return nil, fmt.Errorf("Bad protobuf length in field %v", field.number)
+			}
+			field.bytes = b[n : n+int(length)]
+			b = b[n+int(length):]
+		case protoI64:
+			if len(b) < 8 {
+				return nil, fmt.Errorf("Short protobuf field %v", field.number)
+			}
+			b = b[8:]
+		case protoI32:
+			if len(b) < 4 {
+				return nil, fmt.Errorf("Short protobuf field %v", field.number)
+			}
+			b = b[4:]
+		default:
+			return nil, fmt.Errorf("Unsupported protobuf wire type %v in field %v", field.wireType, field.number)
+		}
+
+		result = append(result, field)
+	}
+
+	return result, nil
+}
+
+// Decode one entry of a map<string, string> field
+func protoParseMapEntry(b []byte) (string, string, error) {
+	fields, err := protoParse(b)
+	if err != nil {
+		return "", "", err
+	}
+
+	var key, value string
+	for _, field := range fields {
+		switch field.number {
+		case 1:
+			key = string(field.bytes)
+		case 2:
+			value = string(field.bytes)
+		}
+	}
+
+	return key, value, nil
+}
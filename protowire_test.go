@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestProtoRoundTrip(t *testing.T) {
+	var b []byte
+	b = protoAppendUint(b, 1, 300)
+	b = protoAppendString(b, 2, "abc")
+	b = protoAppendBool(b, 3, true)
+	b = protoAppendMapEntry(b, 20, "io.kubernetes.pod.name", "frontend")
+
+	fields, err := protoParse(b)
+	if err != nil {
+		t.Fatalf("Couldn't parse message: %v", err)
+	}
+	if len(fields) != 4 {
+		t.Fatalf("Got %v fields, expected 4", len(fields))
+	}
+
+	expectEqual(t, fields[0].number, 1, "Unexpected number of field 0")
+	expectEqual(t, fields[0].wireType, protoVarint, "Unexpected wire type of field 0")
+	expectEqual(t, fields[0].varint, uint64(300), "Unexpected value of field 0")
+
+	expectEqual(t, fields[1].number, 2, "Unexpected number of field 1")
+	expectEqual(t, fields[1].wireType, protoLen, "Unexpected wire type of field 1")
+	expectEqual(t, string(fields[1].bytes), "abc", "Unexpected value of field 1")
+
+	expectEqual(t, fields[2].varint, uint64(1), "Unexpected value of field 2")
+
+	expectEqual(t, fields[3].number, 20, "Unexpected number of field 3")
+	key, value, err := protoParseMapEntry(fields[3].bytes)
+	if err != nil {
+		t.Fatalf("Couldn't parse map entry: %v", err)
+	}
+	expectEqual(t, key, "io.kubernetes.pod.name", "Unexpected map key")
+	expectEqual(t, value, "frontend", "Unexpected map value")
+}
+
+func TestProtoParseSkipsFixedFields(t *testing.T) {
+	b := protoAppendTag(nil, 1, protoI64)
+	b = append(b, 1, 2, 3, 4, 5, 6, 7, 8)
+	b = protoAppendTag(b, 2, protoI32)
+	b = append(b, 1, 2, 3, 4)
+	b = protoAppendString(b, 3, "x")
+
+	fields, err := protoParse(b)
+	if err != nil {
+		t.Fatalf("Couldn't parse message: %v", err)
+	}
+	if len(fields) != 3 {
+		t.Fatalf("Got %v fields, expected 3", len(fields))
+	}
+	expectEqual(t, string(fields[2].bytes), "x", "Unexpected value after fixed-width fields")
+}
+
+func TestProtoParseErrors(t *testing.T) {
+	truncated := protoAppendString(nil, 1, "abcdef")
+	truncated = truncated[:len(truncated)-1]
+
+	for _, b := range [][]byte{
+		truncated,
+		{0x08},       // A varint field with no value
+		{0x09, 1, 2}, // A short fixed64 field
+		{0x0b},       // Start group, which we don't support
+	} {
+		_, err := protoParse(b)
+		if err == nil {
+			t.Errorf("Expected an error parsing %v", b)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+)
+
+// Parse /etc/services into a map from "port/protocol", like "443/tcp",
+// to service names, like "https"
+func parseServices(services io.Reader) (map[string]string, error) {
+	result := make(map[string]string)
+
+	lines := bufio.NewScanner(services)
+	for lines.Scan() {
+		line := lines.Text()
+		if comment := strings.IndexByte(line, '#'); comment != -1 {
+			line = line[:comment]
+		}
+
+		// Lines look like
+		//   https  443/tcp  [aliases...]
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		// The first name listed for a port wins, like in
+		// getservbyport
+		if _, ok := result[fields[1]]; !ok {
+			result[fields[1]] = fields[0]
+		}
+	}
+
+	if err := lines.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Load the service names from /etc/services. If it doesn't exist, we
+// just don't know any names.
+func loadServices() (map[string]string, error) {
+	fp, err := os.Open("/etc/services")
+	if os.IsNotExist(err) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	return parseServices(fp)
+}
+
+// Replace the IP addresses and port numbers in conns with host and
+// service names, like netstat does without --numeric. services maps
+// "port/protocol" to names, like parseServices returns, and cache does
+// the reverse DNS lookups. Addresses and ports without names are left
+// alone.
+func resolveConnectionNames(conns []Connection, services map[string]string, cache *ReverseDNSCache) {
+	var hosts []string
+	for _, conn := range conns {
+		hosts = append(hosts, conn.localHost, conn.remoteHost)
+	}
+	hostNames := cache.lookupAll(hosts)
+
+	resolveHost := func(host string) string {
+		name, ok := hostNames[host]
+		if ok {
+			return name
+		}
+		return host
+	}
+
+	resolvePort := func(port string, protocol string) string {
+		name, ok := services[port+"/"+strings.TrimSuffix(protocol, "6")]
+		if ok {
+			return name
+		}
+		return port
+	}
+
+	for i := range conns {
+		conn := &conns[i]
+		conn.localHost = resolveHost(conn.localHost)
+		conn.localPort = resolvePort(conn.localPort, conn.protocol)
+		conn.remoteHost = resolveHost(conn.remoteHost)
+		conn.remotePort = resolvePort(conn.remotePort, conn.protocol)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const servicesFile = `# Network services, Internet style
+ssh		22/tcp				# SSH Remote Login Protocol
+domain		53/tcp				# Domain Name Server
+domain		53/udp
+https		443/tcp				# http protocol over TLS/SSL
+http-alt	8080/tcp	webcache	# WWW caching service
+webcache	8080/udp
+`
+
+func TestParseServices(t *testing.T) {
+	services, err := parseServices(strings.NewReader(servicesFile))
+	if err != nil {
+		t.Fatalf("Got error %v from parseServices", err)
+	}
+
+	expected := map[string]string{
+		"22/tcp":   "ssh",
+		"53/tcp":   "domain",
+		"53/udp":   "domain",
+		"443/tcp":  "https",
+		"8080/tcp": "http-alt",
+		"8080/udp": "webcache",
+	}
+	if fmt.Sprint(services) != fmt.Sprint(expected) {
+		t.Errorf("Got services %v, expected %v", services, expected)
+	}
+}
+
+func TestResolveConnectionNames(t *testing.T) {
+	services, _ := parseServices(strings.NewReader(servicesFile))
+
+	// Lookups run concurrently, so count them under a lock
+	var lock sync.Mutex
+	lookups := 0
+	lookupAddr := func(ctx context.Context, addr string) ([]string, error) {
+		lock.Lock()
+		lookups += 1
+		lock.Unlock()
+		if addr == "10.0.3.4" {
+			return []string{"backend.example.com."}, nil
+		}
+		return nil, fmt.Errorf("no name for %v", addr)
+	}
+	cache := newReverseDNSCache(lookupAddr, time.Second)
+
+	conns := []Connection{
+		Connection{protocol: "tcp", localHost: "10.0.1.5", localPort: "35406",
+			remoteHost: "10.0.3.4", remotePort: "443"},
+		Connection{protocol: "udp6", localHost: "::", localPort: "53",
+			remoteHost: "10.0.3.4", remotePort: "*"},
+	}
+	resolveConnectionNames(conns, services, cache)
+
+	expected := []Connection{
+		Connection{protocol: "tcp", localHost: "10.0.1.5", localPort: "35406",
+			remoteHost: "backend.example.com", remotePort: "https"},
+		Connection{protocol: "udp6", localHost: "::", localPort: "domain",
+			remoteHost: "backend.example.com", remotePort: "*"},
+	}
+	for i := range expected {
+		if conns[i] != expected[i] {
+			t.Errorf("Got connection %v, expected %v", conns[i], expected[i])
+		}
+	}
+
+	// 10.0.1.5 and 10.0.3.4 should each be looked up once, and ::
+	// not at all
+	if lookups != 2 {
+		t.Errorf("Expected 2 lookups, got %v", lookups)
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+)
+
+// Which container runtime we ask for the containers on this node
+type Runtime int
+const (
+	autoRuntime Runtime = iota
+	dockerRuntime
+	containerdRuntime
+	crioRuntime
+	podmanRuntime
+	noRuntime
+)
+
+// A RuntimeContainer is a running container, as reported by a
+// container runtime
+type RuntimeContainer struct {
+	id       string
+	pid      int    // The container's root PID on the host
+	podUid   string // The Kubernetes UID of the container's pod, if it has one
+	attempt  int    // How many times kubelet restarted the container
+	image    string
+	kubePath ContainerPath
+}
+
+// A containerRuntime says how to list the containers of one runtime
+type containerRuntime struct {
+	name           string
+	socket         string
+	listContainers func(socket string) ([]RuntimeContainer, error)
+}
+
+// The kinds of containers we know of
+const (
+	kubernetesKind = "kubernetes" // A container in a Kubernetes pod
+	composeKind    = "compose"    // A container that docker compose started
+	podmanPodKind  = "podman-pod" // A container in a Podman pod
+	containerKind  = "container"  // Any other container
+	hostKind       = "host"       // A process outside any container we know of
+)
+
+// The labels docker compose puts on the containers it creates
+const (
+	composeProjectLabel = "com.docker.compose.project"
+	composeServiceLabel = "com.docker.compose.service"
+)
+
+// The labels kubelet puts on the containers it creates
+const (
+	podNameLabel       = "io.kubernetes.pod.name"
+	podNamespaceLabel  = "io.kubernetes.pod.namespace"
+	containerNameLabel = "io.kubernetes.container.name"
+	podUidLabel        = "io.kubernetes.pod.uid"
+	restartCountLabel  = "io.kubernetes.container.restartCount"
+)
+
+// Find a container's ContainerPath in its labels. Containers that
+// kubelet didn't create get an empty ContainerPath.
+func kubePathFromLabels(labels map[string]string) ContainerPath {
+	if labels[podNameLabel] == "" {
+		return ContainerPath{}
+	}
+
+	return ContainerPath{
+		PodNamespace:  labels[podNamespaceLabel],
+		PodName:       labels[podNameLabel],
+		ContainerName: labels[containerNameLabel],
+		Kind:          kubernetesKind,
+	}
+}
+
+// Make a ContainerPath for a container that isn't in a Kubernetes pod,
+// from its name and labels. Compose containers get their project as
+// their namespace and their service as their pod. Other containers
+// only have a container name.
+func plainContainerPath(name string, labels map[string]string) ContainerPath {
+	if project := labels[composeProjectLabel]; project != "" {
+		return ContainerPath{
+			PodNamespace:  project,
+			PodName:       labels[composeServiceLabel],
+			ContainerName: name,
+			Kind:          composeKind,
+		}
+	}
+
+	return ContainerPath{ContainerName: name, Kind: containerKind}
+}
+
+// Make an http.Transport that connects to the Unix socket at socket,
+// whatever host a request's URL names
+func unixSocketTransport(socket string) *http.Transport {
+	return &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		},
+	}
+}
+
+// Build a map from host PIDs to ContainerPaths from a list of
+// containers
+func pidMapFromContainers(containers []RuntimeContainer) map[int]ContainerPath {
+	pidMap := make(map[int]ContainerPath)
+
+	for _, container := range containers {
+		if container.pid != 0 {
+			pidMap[container.pid] = container.kubePath
+		}
+	}
+
+	return pidMap
+}
+
+// The runtimes to ask for containers with config. For autoRuntime,
+// that's every runtime we support, at its default socket.
+func configuredRuntimes(config CnetstatConfig) []containerRuntime {
+	criSocket := func(defaultSocket string) string {
+		if config.criSocket != "" {
+			return config.criSocket
+		}
+		return defaultSocket
+	}
+
+	docker := containerRuntime{"docker", defaultDockerSocket, listDockerContainers}
+	containerd := containerRuntime{"containerd", criSocket(defaultContainerdSocket), listCriContainers}
+	crio := containerRuntime{"cri-o", criSocket(defaultCrioSocket), listCriContainers}
+	podman := containerRuntime{"podman", defaultPodmanSocket, listPodmanContainers}
+
+	switch config.runtime {
+	case dockerRuntime:
+		return []containerRuntime{docker}
+	case containerdRuntime:
+		return []containerRuntime{containerd}
+	case crioRuntime:
+		return []containerRuntime{crio}
+	case podmanRuntime:
+		return []containerRuntime{podman}
+	case noRuntime:
+		return nil
+	default:
+		containerd.socket = defaultContainerdSocket
+		crio.socket = defaultCrioSocket
+		return []containerRuntime{docker, containerd, crio, podman}
+	}
+}
+
+// Keep the runtimes whose sockets exist on this node
+func detectRuntimes(runtimes []containerRuntime) []containerRuntime {
+	var result []containerRuntime
+
+	for _, runtime := range runtimes {
+		info, err := os.Stat(runtime.socket)
+		if err == nil && info.Mode()&os.ModeSocket != 0 {
+			result = append(result, runtime)
+		}
+	}
+
+	return result
+}
+
+// Ask each runtime for its containers, and merge their lists. Nodes can have several runtimes
+// installed, and not all of them answer (Docker's containerd has CRI
+// disabled, for example), so we only fail if every runtime does.
+func queryRuntimes(runtimes []containerRuntime) ([]RuntimeContainer, error) {
+	var containers []RuntimeContainer
+	var firstErr error
+	answered := false
+
+	for _, runtime := range runtimes {
+		runtimeContainers, err := runtime.listContainers(runtime.socket)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("Couldn't list %v containers: %v", runtime.name, err)
+			}
+			continue
+		}
+
+		answered = true
+		containers = append(containers, runtimeContainers...)
+	}
+
+	if !answered {
+		return nil, firstErr
+	}
+
+	return containers, nil
+}
+
+// Build a PodResolver for the containers of the container runtimes and
+// the mapping file in config
+func buildPodResolver(config CnetstatConfig) (*PodResolver, error) {
+	var static *StaticMapping
+	if config.mappingFile != "" {
+		var err error
+		static, err = loadMappingFile(config.mappingFile)
+		if err != nil {
+			return nil, err
+		}
+		// With names instead of IPs, CIDR entries would silently
+		// stop matching
+		if len(static.cidrs) > 0 && !config.numeric {
+			return nil, fmt.Errorf("--mapping-file with cidr entries needs --numeric")
+		}
+	}
+
+	runtimes := configuredRuntimes(config)
+
+	if config.runtime == autoRuntime {
+		runtimes = detectRuntimes(runtimes)
+		// The mapping file can stand in for a runtime
+		if len(runtimes) == 0 && static == nil {
+			return nil, fmt.Errorf("Couldn't find a container runtime socket. Use --runtime to pick a runtime")
+		}
+	}
+
+	containers, err := queryRuntimes(runtimes)
+	if err != nil {
+		return nil, err
+	}
+
+	resolver := newPodResolver("/proc", containers)
+	resolver.static = static
+	return resolver, nil
+}
+
+// Get a PodResolver for the next snapshot when we print them
+// periodically. Asking the runtimes for their containers is the slow
+// part, so we keep using resolver unless it found containers they
+// didn't tell us about, or the root process of a container they did
+// tell us about is gone. We always forget the PIDs it resolved, since
+// processes come and go, and the kernel reuses their PIDs.
+func refreshPodResolver(config CnetstatConfig, resolver *PodResolver) (*PodResolver, error) {
+	if resolver.missedContainers || resolver.rootPidsChanged() {
+		return buildPodResolver(config)
+	}
+
+	resolver.cache = make(map[int]resolvedPid)
+	return resolver, nil
+}
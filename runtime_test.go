@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPidMapFromContainers(t *testing.T) {
+	frontend := ContainerPath{"my-app", "frontend", "fe-server", kubernetesKind}
+	backend := ContainerPath{"my-app", "backend", "be-server", kubernetesKind}
+
+	pidMap := pidMapFromContainers([]RuntimeContainer{
+		{id: "56443455", pid: 36, kubePath: frontend},
+		{id: "65323bda", pid: 9486, kubePath: backend},
+		{id: "a01098fd", pid: 0, kubePath: backend},
+	})
+
+	expectEqual(t, len(pidMap), 2, "Unexpected pid map size")
+	expectEqual(t, pidMap[36], frontend, "Unexpected ContainerPath for PID 36")
+	expectEqual(t, pidMap[9486], backend, "Unexpected ContainerPath for PID 9486")
+}
+
+func TestKubePathFromLabels(t *testing.T) {
+	path := kubePathFromLabels(map[string]string{
+		"io.kubernetes.pod.name":       "frontend",
+		"io.kubernetes.pod.namespace":  "my-app",
+		"io.kubernetes.container.name": "fe-server",
+		"component":                    "foo",
+	})
+	expectEqual(t, path, ContainerPath{"my-app", "frontend", "fe-server", kubernetesKind}, "Unexpected ContainerPath from labels")
+
+	expectEqual(t, kubePathFromLabels(nil), ContainerPath{}, "Expected an empty ContainerPath without labels")
+}
+
+func TestPlainContainerPath(t *testing.T) {
+	path := plainContainerPath("shop-web-1", map[string]string{
+		"com.docker.compose.project": "shop",
+		"com.docker.compose.service": "web",
+	})
+	expectEqual(t, path, ContainerPath{"shop", "web", "shop-web-1", composeKind}, "Unexpected ContainerPath of a Compose container")
+
+	path = plainContainerPath("pensive_turing", nil)
+	expectEqual(t, path, ContainerPath{"", "", "pensive_turing", containerKind}, "Unexpected ContainerPath of a plain container")
+}
+
+func TestDetectRuntimes(t *testing.T) {
+	dir := t.TempDir()
+
+	socket := filepath.Join(dir, "containerd.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Couldn't listen on %v: %v", socket, err)
+	}
+	defer listener.Close()
+
+	notSocket := filepath.Join(dir, "docker.sock")
+	err = os.WriteFile(notSocket, nil, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runtimes := detectRuntimes([]containerRuntime{
+		{name: "docker", socket: notSocket},
+		{name: "containerd", socket: socket},
+		{name: "cri-o", socket: filepath.Join(dir, "crio.sock")},
+	})
+
+	if len(runtimes) != 1 {
+		t.Fatalf("Detected %v runtimes, expected 1", len(runtimes))
+	}
+	expectEqual(t, runtimes[0].name, "containerd", "Detected the wrong runtime")
+}
+
+func TestQueryRuntimes(t *testing.T) {
+	frontend := ContainerPath{"my-app", "frontend", "fe-server", kubernetesKind}
+	backend := ContainerPath{"my-app", "backend", "be-server", kubernetesKind}
+
+	list := func(containers []RuntimeContainer, err error) func(string) ([]RuntimeContainer, error) {
+		return func(string) ([]RuntimeContainer, error) { return containers, err }
+	}
+	broken := containerRuntime{"containerd", "", list(nil, fmt.Errorf("unknown service runtime.v1.RuntimeService"))}
+
+	containers, err := queryRuntimes([]containerRuntime{
+		{"docker", "", list([]RuntimeContainer{{id: "56443455", pid: 36, kubePath: frontend}}, nil)},
+		broken,
+		{"podman", "", list([]RuntimeContainer{{id: "65323bda", pid: 9486, kubePath: backend}}, nil)},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(containers) != 2 {
+		t.Fatalf("Got %v containers, expected 2", len(containers))
+	}
+	expectEqual(t, containers[0].kubePath, frontend, "Unexpected ContainerPath of the Docker container")
+	expectEqual(t, containers[1].kubePath, backend, "Unexpected ContainerPath of the Podman container")
+
+	_, err = queryRuntimes([]containerRuntime{broken})
+	if err == nil {
+		t.Errorf("Expected an error when every runtime fails")
+	}
+}
+
+func TestRefreshPodResolver(t *testing.T) {
+	mappingFile := filepath.Join(t.TempDir(), "mapping.json")
+	err := os.WriteFile(mappingFile, []byte(`[{"pid": 36, "container": "fe-server"}]`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := CnetstatConfig{runtime: noRuntime, mappingFile: mappingFile}
+
+	resolver, err := buildPodResolver(config)
+	if err != nil {
+		t.Fatalf("Couldn't build a PodResolver: %v", err)
+	}
+	resolver.cache[36] = resolvedPid{path: ContainerPath{ContainerName: "old"}}
+
+	refreshed, err := refreshPodResolver(config, resolver)
+	expectEqual(t, err, nil, "Unexpected error refreshing the PodResolver")
+	if refreshed != resolver {
+		t.Errorf("Expected to keep the PodResolver when the containers haven't changed")
+	}
+	expectEqual(t, len(refreshed.cache), 0, "Expected to forget resolved PIDs")
+
+	resolver.missedContainers = true
+	refreshed, err = refreshPodResolver(config, resolver)
+	expectEqual(t, err, nil, "Unexpected error refreshing the PodResolver")
+	if refreshed == resolver {
+		t.Errorf("Expected a new PodResolver after missing containers")
+	}
+	expectEqual(t, refreshed.missedContainers, false, "Expected the new PodResolver to know every container")
+
+	// A container whose root process is now another process
+	resolver = refreshed
+	resolver.rootStarts[os.Getpid()] = 1
+	refreshed, err = refreshPodResolver(config, resolver)
+	expectEqual(t, err, nil, "Unexpected error refreshing the PodResolver")
+	if refreshed == resolver {
+		t.Errorf("Expected a new PodResolver after a root PID changed")
+	}
+}
+
+func TestBuildPodResolverCidrsNeedNumeric(t *testing.T) {
+	mappingFile := filepath.Join(t.TempDir(), "mapping.json")
+	err := os.WriteFile(mappingFile, []byte(`[{"cidr": "127.0.0.0/8", "container": "lo"}]`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = buildPodResolver(CnetstatConfig{runtime: noRuntime, mappingFile: mappingFile, numeric: true})
+	expectEqual(t, err, nil, "Unexpected error building a PodResolver with numeric addresses")
+
+	_, err = buildPodResolver(CnetstatConfig{runtime: noRuntime, mappingFile: mappingFile, numeric: false})
+	if err == nil {
+		t.Errorf("Expected an error for CIDR entries with --numeric=false")
+	}
+}
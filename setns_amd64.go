@@ -0,0 +1,4 @@
+package main
+
+// The syscall package doesn't define SYS_SETNS on amd64
+const sysSetns = 308
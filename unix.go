@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// A Unix domain socket, as listed in /proc/net/unix
+type UnixSocket struct {
+	socketType string // "STREAM", "DGRAM" or "SEQPACKET"
+	state      string // "LISTENING", "CONNECTED", etc., or "" if unconnected
+	path       string // "" if unnamed. Abstract sockets start with "@"
+	inode      uint64
+	pid        int // 0 if unknown
+	netns      int // The inode of the socket's net namespace
+}
+
+// Socket types and states as numbered in /proc/net/unix, named the way
+// netstat names them
+var unixSocketTypes = map[uint64]string{
+	1: "STREAM",
+	2: "DGRAM",
+	3: "RAW",
+	4: "RDM",
+	5: "SEQPACKET",
+}
+
+var unixSocketStates = map[uint64]string{
+	0: "FREE",
+	1: "",
+	2: "CONNECTING",
+	3: "CONNECTED",
+	4: "DISCONNECTING",
+}
+
+// __SO_ACCEPTCON, which the kernel sets in the flags of listening
+// sockets
+const unixAcceptCon = 1 << 16
+
+// Parse the contents of /proc/net/unix. The pids of the resulting
+// UnixSockets will be 0.
+func parseProcNetUnix(output io.Reader) ([]UnixSocket, error) {
+	lines := bufio.NewScanner(output)
+
+	lines.Scan()
+	if !strings.HasPrefix(lines.Text(), "Num") {
+		return nil, fmt.Errorf("Unexpected header of /proc/net/unix: %s", lines.Text())
+	}
+
+	var result []UnixSocket
+	for lines.Scan() {
+		// Fields are
+		//   Num RefCount Protocol Flags Type St Inode Path
+		// and Path is missing for unnamed sockets
+		fields := strings.Fields(lines.Text())
+		if len(fields) < 7 {
+			return nil, fmt.Errorf("Couldn't parse /proc/net/unix line: %s", lines.Text())
+		}
+
+		flags, err := strconv.ParseUint(fields[3], 16, 32)
+		if err != nil {
+			return nil, err
+		}
+		typeNum, err := strconv.ParseUint(fields[4], 16, 16)
+		if err != nil {
+			return nil, err
+		}
+		stateNum, err := strconv.ParseUint(fields[5], 16, 8)
+		if err != nil {
+			return nil, err
+		}
+		inode, err := strconv.ParseUint(fields[6], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		socketType, ok := unixSocketTypes[typeNum]
+		if !ok {
+			socketType = "UNKNOWN"
+		}
+
+		var state string
+		if flags&unixAcceptCon != 0 {
+			state = "LISTENING"
+		} else {
+			state, ok = unixSocketStates[stateNum]
+			if !ok {
+				state = "UNKNOWN"
+			}
+		}
+
+		// Paths can contain spaces. This collapses runs of
+		// them, which is good enough to identify a socket.
+		var path string
+		if len(fields) > 7 {
+			path = strings.Join(fields[7:], " ")
+		}
+
+		result = append(result, UnixSocket{
+			socketType: socketType,
+			state:      state,
+			path:       path,
+			inode:      inode,
+		})
+	}
+
+	if err := lines.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Get the Unix domain sockets in the namespace at nsPath. owners maps
+// socket inodes to PIDs, as returned by socketInodeOwners.
+func getUnixSocketsFromNamespace(nsPath string, owners map[uint64]int) ([]UnixSocket, error) {
+	var result []UnixSocket
+
+	err := inNetNamespace(nsPath, func() error {
+		fp, err := os.Open("/proc/thread-self/net/unix")
+		if err != nil {
+			return err
+		}
+		defer fp.Close()
+
+		result, err = parseProcNetUnix(fp)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range result {
+		result[i].pid = owners[result[i].inode]
+	}
+
+	return result, nil
+}
+
+// A Unix domain socket with a Kubernetes container identifier
+type KubeUnixSocket struct {
+	sock      UnixSocket
+	container ContainerPath
+}
+
+var unixSocketHeaders = []string{
+	"Namespace", "Pod", "Container", "Kind", "Type", "State", "Inode", "Path",
+}
+
+func (ks KubeUnixSocket) Fields() []string {
+	return []string{
+		ks.container.PodNamespace,
+		ks.container.PodName,
+		ks.container.ContainerName,
+		ks.container.Kind,
+		ks.sock.socketType,
+		ks.sock.state,
+		strconv.FormatUint(ks.sock.inode, 10),
+		ks.sock.path,
+	}
+}
+
+// Map UnixSockets with PIDs into KubeUnixSockets with container
+// identifiers
+func getKubeUnixSockets(sockets []UnixSocket, resolver *PodResolver) []KubeUnixSocket {
+	kubeSockets := make([]KubeUnixSocket, len(sockets))
+
+	for i, sock := range sockets {
+		path := resolver.socketToPod(sock.pid, sock.netns, "")
+
+		kubeSockets[i] = KubeUnixSocket{
+			sock:      sock,
+			container: path,
+		}
+	}
+
+	return kubeSockets
+}
+
+// Get Unix domain sockets from namespaces, and build the table of them
+// to print, with its column headers
+func unixSocketTable(config CnetstatConfig, namespaces []NamespaceData, resolver *PodResolver) ([]Fielder, []string, error) {
+	owners, err := socketInodeOwners("/proc")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var sockets []UnixSocket
+	for _, namespace := range namespaces {
+		nsSockets, err := getUnixSocketsFromNamespace(namespace.nsPath(), owners)
+		if err != nil {
+			err = namespaceError(config, namespace, err)
+			if err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+
+		for i := range nsSockets {
+			nsSockets[i].netns = namespace.Ns
+		}
+		sockets = append(sockets, nsSockets...)
+	}
+
+	kubeSockets := getKubeUnixSockets(sockets, resolver)
+
+	table := make([]Fielder, len(kubeSockets))
+	for i := range kubeSockets {
+		table[i] = &kubeSockets[i]
+	}
+
+	return table, unixSocketHeaders, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// This should match the format of /proc/net/unix
+const procNetUnixOutput = `Num       RefCount Protocol Flags    Type St Inode Path
+0000000000000000: 00000002 00000000 00010000 0001 01 21340 /var/run/docker.sock
+0000000000000000: 00000003 00000000 00000000 0001 03 19998
+0000000000000000: 00000002 00000000 00000000 0002 01 16543 @envoy_domain_socket_admin
+0000000000000000: 00000003 00000000 00000000 0005 03 30211 /tmp/my socket
+`
+
+var procNetUnixExpectedParse = []UnixSocket{
+	UnixSocket{socketType: "STREAM", state: "LISTENING", path: "/var/run/docker.sock", inode: 21340},
+	UnixSocket{socketType: "STREAM", state: "CONNECTED", path: "", inode: 19998},
+	UnixSocket{socketType: "DGRAM", state: "", path: "@envoy_domain_socket_admin", inode: 16543},
+	UnixSocket{socketType: "SEQPACKET", state: "CONNECTED", path: "/tmp/my socket", inode: 30211},
+}
+
+func TestParseProcNetUnix(t *testing.T) {
+	sockets, err := parseProcNetUnix(strings.NewReader(procNetUnixOutput))
+	if err != nil {
+		t.Fatalf("Got error %v from parseProcNetUnix", err)
+	}
+
+	if len(sockets) != len(procNetUnixExpectedParse) {
+		t.Fatalf("Got %v sockets, expected %v", len(sockets), len(procNetUnixExpectedParse))
+	}
+
+	for i, expected := range procNetUnixExpectedParse {
+		if sockets[i] != expected {
+			t.Errorf("Got socket %v, expected %v", sockets[i], expected)
+		}
+	}
+}
+
+func TestGetKubeUnixSockets(t *testing.T) {
+	container := ContainerPath{
+		PodNamespace:  "kube-system",
+		PodName:       "envoy",
+		ContainerName: "proxy",
+	}
+	resolver := newPodResolver(t.TempDir(), []RuntimeContainer{{id: "0f5c", pid: 4821, kubePath: container}})
+
+	sockets := []UnixSocket{
+		UnixSocket{socketType: "DGRAM", path: "@envoy_domain_socket_admin", inode: 16543, pid: 4821},
+	}
+
+	kubeSockets := getKubeUnixSockets(sockets, resolver)
+	if len(kubeSockets) != 1 || kubeSockets[0].container != container {
+		t.Errorf("Got %v, expected the socket to be in %v", kubeSockets, container)
+	}
+}
+
+func TestGetKubeUnixSocketsWithMappingFile(t *testing.T) {
+	mapping, err := parseMappingFile(strings.NewReader(`[
+	  {"pid": 4821, "namespace": "kube-system", "pod": "envoy", "container": "proxy"},
+	  {"netns": 4026532301, "namespace": "my-app", "pod": "backend", "container": "be-server"}
+	]`))
+	if err != nil {
+		t.Fatalf("Couldn't parse mapping file: %v", err)
+	}
+	resolver := newPodResolver(t.TempDir(), nil)
+	resolver.static = mapping
+
+	sockets := []UnixSocket{
+		UnixSocket{socketType: "DGRAM", path: "@envoy_domain_socket_admin", inode: 16543, pid: 4821},
+		// A socket whose PID we don't know, in a mapped namespace
+		UnixSocket{socketType: "STREAM", path: "/tmp/be.sock", inode: 16544, netns: 4026532301},
+	}
+
+	kubeSockets := getKubeUnixSockets(sockets, resolver)
+	expectEqual(t, kubeSockets[0].container, ContainerPath{"kube-system", "envoy", "proxy", kubernetesKind},
+		"Expected the mapping file's PID entry to apply to Unix sockets")
+	expectEqual(t, kubeSockets[1].container, ContainerPath{"my-app", "backend", "be-server", kubernetesKind},
+		"Expected the mapping file's netns entry to apply to Unix sockets")
+}
+
+func TestPrintUnixSocketsAsJson(t *testing.T) {
+	// Socket paths can have any characters but NUL in them
+	path := "/tmp/a \"quoted\" \\ path\x01 <&>"
+	sockets := []Fielder{
+		KubeUnixSocket{sock: UnixSocket{socketType: "STREAM", state: "LISTENING", path: path, inode: 21340}},
+	}
+
+	var buf bytes.Buffer
+	printJsonTable(sockets, unixSocketHeaders, &buf)
+
+	var row map[string]string
+	err := json.Unmarshal(buf.Bytes(), &row)
+	if err != nil {
+		t.Fatalf("printJsonTable wrote invalid JSON %v: %v", buf.String(), err)
+	}
+	expectEqual(t, row["Path"], path, "Unexpected socket path from JSON")
+	if !strings.Contains(buf.String(), "<&>") {
+		t.Errorf("Expected <, > and & to be left alone in %v", buf.String())
+	}
+}
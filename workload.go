@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// How summaries group connections from pods
+type GroupBy int
+const (
+	groupByPod GroupBy = iota
+	groupByWorkload
+)
+
+// CronJobs name their Jobs after themselves and the scheduled time, in
+// minutes since the epoch, like "backup-28912345"
+const cronJobSuffixMinLen = 8
+
+// Is s a non-empty string of digits?
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Find the workload that owns a pod, like "Deployment/frontend". Pods
+// without a controller, and static pods (which nodes own), are their
+// own workloads. Pods only tell us their direct owner, so we use the
+// owner's owner from the API server when fetchWorkloadOwners found
+// it, and guess it from names otherwise.
+func (pod *PodInfo) workload() string {
+	switch pod.ownerKind {
+	case "":
+		return "Pod/" + pod.name
+	case "Node":
+		return "Pod/" + pod.name
+	}
+
+	if pod.ownerWorkload != "" {
+		return pod.ownerWorkload
+	}
+	return pod.guessWorkload()
+}
+
+// The fallback of workload(), for when we can't ask the API server:
+// recognize the owners' owners by their naming conventions. A
+// Deployment names its ReplicaSets <deployment>-<pod-template-hash>,
+// and a CronJob names its Jobs <cronjob>-<scheduled time>. This is
+// wrong for ReplicaSets and Jobs that people name like that
+// themselves.
+func (pod *PodInfo) guessWorkload() string {
+	switch pod.ownerKind {
+	case "ReplicaSet":
+		hash := pod.labels["pod-template-hash"]
+		if hash != "" && strings.HasSuffix(pod.ownerName, "-"+hash) {
+			return "Deployment/" + strings.TrimSuffix(pod.ownerName, "-"+hash)
+		}
+	case "Job":
+		i := strings.LastIndex(pod.ownerName, "-")
+		if i > 0 {
+			suffix := pod.ownerName[i+1:]
+			if len(suffix) >= cronJobSuffixMinLen && isDigits(suffix) {
+				return "CronJob/" + pod.ownerName[:i]
+			}
+		}
+	}
+
+	return pod.owner()
+}
+
+// Where the API server serves the kinds of pod controllers that other
+// controllers usually own
+var ownedControllerPaths = map[string]string{
+	"ReplicaSet": "/apis/apps/v1/namespaces/%v/replicasets/%v",
+	"Job":        "/apis/batch/v1/namespaces/%v/jobs/%v",
+}
+
+// The fields we use of a ReplicaSet or Job from the API server
+type ownedController struct {
+	Metadata struct {
+		OwnerReferences []ownerReference
+	}
+}
+
+// Ask the API server that config describes who owns each pod's
+// ReplicaSet or Job, and set the pod's ownerWorkload. The keys of
+// pods are ContainerPaths without container names, like
+// fetchKubeletPods returns. We skip controllers that the API server
+// doesn't have any more or won't show us, and stop asking if we can't
+// reach it, and workload() guesses those controllers' owners from
+// their names. Returns an error only if config is bad.
+func fetchWorkloadOwners(config KubeAPIConfig, pods map[ContainerPath]*PodInfo) error {
+	client, authorization, err := newKubeAPIClient(config)
+	if err != nil {
+		return err
+	}
+
+	// Many pods share a controller, so only ask about each one once
+	workloads := make(map[string]string)
+	for key, pod := range pods {
+		pathFormat, ok := ownedControllerPaths[pod.ownerKind]
+		if !ok {
+			continue
+		}
+		path := fmt.Sprintf(pathFormat, url.PathEscape(key.PodNamespace), url.PathEscape(pod.ownerName))
+
+		workload, ok := workloads[path]
+		if !ok {
+			workload, err = fetchControllerWorkload(client, authorization, strings.TrimSuffix(config.url, "/")+path, pod.owner())
+			if err != nil {
+				// Don't wait for every other request to
+				// fail the same way
+				fmt.Fprintf(os.Stderr, "Guessing workloads from pod owners' names, since we couldn't ask the API server: %v\n", err)
+				return nil
+			}
+			workloads[path] = workload
+		}
+		pod.ownerWorkload = workload
+	}
+
+	return nil
+}
+
+// Replace the pod name of every connection from a pod that kubelet
+// told us about with the pod's workload, so that summaries count the
+// connections of all of a workload's pods together. The connections
+// lose their PodInfo, since it describes a single pod.
+func rollUpToWorkloads(connections []KubeConnection) {
+	for i := range connections {
+		pod := connections[i].pod
+		if pod == nil {
+			continue
+		}
+
+		connections[i].container.PodName = pod.workload()
+		connections[i].pod = nil
+	}
+}
+
+// connectionStatFields, with the Pod column renamed to Workload
+func workloadStatFields() []string {
+	fields := make([]string, len(connectionStatFields))
+	for i, field := range connectionStatFields {
+		if field == "Pod" {
+			field = "Workload"
+		}
+		fields[i] = field
+	}
+	return fields
+}
+
+// Get the controller at objectUrl from the API server, and return its owner,
+// like "Deployment/frontend", or controller itself if nothing owns it.
+// Returns "" if the API server doesn't have it or won't show it to us.
+func fetchControllerWorkload(client *http.Client, authorization string, objectUrl string, controller string) (string, error) {
+	req, err := http.NewRequest("GET", objectUrl, nil)
+	if err != nil {
+		return "", err
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusForbidden:
+		return "", nil
+	default:
+		return "", fmt.Errorf("API server request for %v failed with HTTP status %v", controller, resp.Status)
+	}
+
+	var obj ownedController
+	err = json.NewDecoder(resp.Body).Decode(&obj)
+	if err != nil {
+		return "", fmt.Errorf("Couldn't parse API server response for %v: %v", controller, err)
+	}
+
+	kind, name := controllerOf(obj.Metadata.OwnerReferences)
+	if kind == "" {
+		return controller, nil
+	}
+	return kind + "/" + name, nil
+}
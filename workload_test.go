@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPodWorkload(t *testing.T) {
+	for _, test := range []struct {
+		pod      PodInfo
+		expected string
+	}{
+		{PodInfo{name: "frontend-7d9f8c6b5-x2x4z", ownerKind: "ReplicaSet", ownerName: "frontend-7d9f8c6b5",
+			labels: map[string]string{"pod-template-hash": "7d9f8c6b5"}}, "Deployment/frontend"},
+		// A ReplicaSet that no Deployment made
+		{PodInfo{name: "legacy-abcde", ownerKind: "ReplicaSet", ownerName: "legacy"}, "ReplicaSet/legacy"},
+		{PodInfo{name: "db-0", ownerKind: "StatefulSet", ownerName: "db"}, "StatefulSet/db"},
+		{PodInfo{name: "fluentd-q8w2e", ownerKind: "DaemonSet", ownerName: "fluentd"}, "DaemonSet/fluentd"},
+		{PodInfo{name: "backup-28912345-k2j4h", ownerKind: "Job", ownerName: "backup-28912345"}, "CronJob/backup"},
+		// A Job that no CronJob made
+		{PodInfo{name: "migrate-2-h5g6f", ownerKind: "Job", ownerName: "migrate-2"}, "Job/migrate-2"},
+		{PodInfo{name: "kube-apiserver-kube-node-1", ownerKind: "Node", ownerName: "kube-node-1"}, "Pod/kube-apiserver-kube-node-1"},
+		{PodInfo{name: "debug"}, "Pod/debug"},
+		{PodInfo{name: "canary-6f7g8", ownerKind: "Rollout", ownerName: "canary"}, "Rollout/canary"},
+		// The API server knows better than the names
+		{PodInfo{name: "web-2-h5g6f", ownerKind: "ReplicaSet", ownerName: "web-2", ownerWorkload: "Deployment/web"}, "Deployment/web"},
+		{PodInfo{name: "backup-28912345-k2j4h", ownerKind: "Job", ownerName: "backup-28912345", ownerWorkload: "Job/backup-28912345"},
+			"Job/backup-28912345"},
+	} {
+		expectEqual(t, test.pod.workload(), test.expected, "Unexpected workload of pod "+test.pod.name)
+	}
+}
+
+func TestSummarizeWorkloads(t *testing.T) {
+	frontend := func(pod string) *PodInfo {
+		return &PodInfo{name: pod, ownerKind: "ReplicaSet", ownerName: "frontend-7d9f8c6b5",
+			labels: map[string]string{"pod-template-hash": "7d9f8c6b5"}}
+	}
+	conn := Connection{protocol: "tcp", remoteHost: "10.2.10.82", remotePort: "443", connectionState: "ESTABLISHED"}
+
+	connections := []KubeConnection{
+		{conn: conn, container: ContainerPath{"my-app", "frontend-7d9f8c6b5-x2x4z", "fe-server", kubernetesKind}, pod: frontend("frontend-7d9f8c6b5-x2x4z")},
+		{conn: conn, container: ContainerPath{"my-app", "frontend-7d9f8c6b5-b8n7m", "fe-server", kubernetesKind}, pod: frontend("frontend-7d9f8c6b5-b8n7m")},
+		{conn: conn, container: ContainerPath{"my-app", "frontend-7d9f8c6b5-b8n7m", "fe-server", kubernetesKind}, pod: frontend("frontend-7d9f8c6b5-b8n7m")},
+		{conn: conn},
+	}
+	rollUpToWorkloads(connections)
+	stats := summarizeKubeConnections(connections)
+
+	if len(stats) != 2 {
+		t.Fatalf("Got %v summary rows, expected 2", len(stats))
+	}
+	for _, stat := range stats {
+		switch stat.connId.container.PodName {
+		case "Deployment/frontend":
+			expectEqual(t, stat.count, 3, "Unexpected count of frontend connections")
+			if stat.pod != nil {
+				t.Errorf("Expected no PodInfo in a workload summary")
+			}
+		case "":
+			expectEqual(t, stat.count, 1, "Unexpected count of host connections")
+		default:
+			t.Errorf("Unexpected summary row %v", stat)
+		}
+	}
+
+	expectEqual(t, workloadStatFields()[1], "Workload", "Expected a Workload column")
+	expectEqual(t, connectionStatFields[1], "Pod", "workloadStatFields changed connectionStatFields")
+}
+
+func TestFetchWorkloadOwners(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests += 1
+		switch r.URL.Path {
+		case "/apis/apps/v1/namespaces/my-app/replicasets/web-2":
+			w.Write([]byte(`{"metadata": {"ownerReferences": [
+			  {"kind": "Deployment", "name": "web", "controller": true}]}}`))
+		case "/apis/batch/v1/namespaces/my-app/jobs/backup-28912345":
+			// Someone made this Job by hand
+			w.Write([]byte(`{"metadata": {}}`))
+		case "/apis/apps/v1/namespaces/my-app/replicasets/frontend-7d9f8c6b5":
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	pods := map[ContainerPath]*PodInfo{
+		{PodNamespace: "my-app", PodName: "web-2-h5g6f"}:           {name: "web-2-h5g6f", ownerKind: "ReplicaSet", ownerName: "web-2"},
+		{PodNamespace: "my-app", PodName: "web-2-k8l9m"}:           {name: "web-2-k8l9m", ownerKind: "ReplicaSet", ownerName: "web-2"},
+		{PodNamespace: "my-app", PodName: "backup-28912345-k2j4h"}: {name: "backup-28912345-k2j4h", ownerKind: "Job", ownerName: "backup-28912345"},
+		{PodNamespace: "my-app", PodName: "frontend-7d9f8c6b5-x2x4z"}: {name: "frontend-7d9f8c6b5-x2x4z", ownerKind: "ReplicaSet", ownerName: "frontend-7d9f8c6b5",
+			labels: map[string]string{"pod-template-hash": "7d9f8c6b5"}},
+		{PodNamespace: "my-app", PodName: "db-0"}: {name: "db-0", ownerKind: "StatefulSet", ownerName: "db"},
+	}
+	err := fetchWorkloadOwners(KubeAPIConfig{url: server.URL}, pods)
+	if err != nil {
+		t.Fatalf("Got error %v from fetchWorkloadOwners", err)
+	}
+
+	for name, expected := range map[string]string{
+		"web-2-h5g6f":           "Deployment/web",
+		"web-2-k8l9m":           "Deployment/web",
+		"backup-28912345-k2j4h": "Job/backup-28912345",
+		// We can't look up this ReplicaSet, so we guess from
+		// its name
+		"frontend-7d9f8c6b5-x2x4z": "Deployment/frontend",
+		"db-0":                     "StatefulSet/db",
+	} {
+		pod := pods[ContainerPath{PodNamespace: "my-app", PodName: name}]
+		expectEqual(t, pod.workload(), expected, "Unexpected workload of pod "+name)
+	}
+	expectEqual(t, requests, 3, "Expected one request for each ReplicaSet and Job")
+
+	// An API server we can't reach
+	server.Close()
+	for _, pod := range pods {
+		pod.ownerWorkload = ""
+	}
+	err = fetchWorkloadOwners(KubeAPIConfig{url: server.URL}, pods)
+	if err != nil {
+		t.Fatalf("Expected to guess workloads without the API server, got error %v", err)
+	}
+	for name, expected := range map[string]string{
+		"web-2-h5g6f":              "ReplicaSet/web-2",
+		"backup-28912345-k2j4h":    "CronJob/backup",
+		"frontend-7d9f8c6b5-x2x4z": "Deployment/frontend",
+	} {
+		pod := pods[ContainerPath{PodNamespace: "my-app", PodName: name}]
+		expectEqual(t, pod.workload(), expected, "Unexpected guessed workload of pod "+name)
+	}
+}